@@ -47,9 +47,8 @@ import (
 	etcd "github.com/coreos/etcd/clientv3"
 )
 
-func echoFileOnChange(path string, rc io.ReadCloser) {
-	fmt.Println(path, " modified.")
-	rc.Close()
+func echoFileOnChange(ev *file.Event) {
+	fmt.Println(ev.URL.String(), ev.Op.String())
 }
 
 func echoErrors(errchan chan error) {
@@ -66,12 +65,20 @@ func main() {
 	var etcdUrl string
 	var etcdConfigPath string
 	var etcdUser, etcdPass string
+	var etcdMaxFileSize int64
+	var etcdChunkSize int
 
 	var etcdConfig etcd.Config
 	var etcdClient *etcd.Client
 
 	var radosConfigPath string
 
+	var atomicWrite bool
+	var writeOpts []file.WriteOption
+
+	var watchGlob bool
+	var watchOpts []file.WatchOption
+
 	var args []string
 	var cmd string
 	var u *url.URL
@@ -87,8 +94,16 @@ func main() {
 		"User name to use for authenticating to etcd")
 	flag.StringVar(&etcdPass, "etcd-pass", "",
 		"Password to use for authenticating to etcd")
+	flag.Int64Var(&etcdMaxFileSize, "etcd-max-file-size", fsetcd.MAX_FILE_LEN,
+		"Largest file to write to etcd, in bytes")
+	flag.IntVar(&etcdChunkSize, "etcd-chunk-size", 0,
+		"Size of the chunks to store large files in etcd in, in bytes")
 	flag.StringVar(&radosConfigPath, "rados-config", "",
 		"Path of a Rados configuration to read")
+	flag.BoolVar(&atomicWrite, "atomic-write", false,
+		"Replace files written by the write command atomically")
+	flag.BoolVar(&watchGlob, "glob", false,
+		"Treat the paths given to the watch command as glob patterns")
 	flag.Parse()
 
 	etcdServers = strings.Split(etcdServerList, ",")
@@ -119,7 +134,9 @@ func main() {
 	}
 
 	if etcdClient != nil {
-		fsetcd.RegisterEtcdClient(etcdClient)
+		fsetcd.RegisterEtcdClient(etcdClient,
+			fsetcd.WithMaxFileSize(etcdMaxFileSize),
+			fsetcd.WithChunkSize(etcdChunkSize))
 	}
 
 	if len(radosConfigPath) > 0 {
@@ -165,6 +182,27 @@ func main() {
 				fmt.Println(name)
 			}
 		}
+	case "glob":
+		for _, path := range args {
+			var matches []*url.URL
+			var match *url.URL
+
+			u, err = url.Parse(path)
+			if err != nil {
+				fmt.Printf("%s: Error parsing: %s\n", path, err.Error())
+				continue
+			}
+
+			matches, err = file.Glob(u)
+			if err != nil {
+				fmt.Printf("%s: error expanding: %s\n", u.String(), err.Error())
+				continue
+			}
+
+			for _, match = range matches {
+				fmt.Println(match.String())
+			}
+		}
 	case "cat":
 		for _, path := range args {
 			var rc io.ReadCloser
@@ -193,6 +231,61 @@ func main() {
 					err.Error())
 			}
 		}
+	case "stat":
+		for _, path := range args {
+			var fi os.FileInfo
+			u, err = url.Parse(path)
+			if err != nil {
+				fmt.Printf("%s: Error parsing: %s\n", path, err.Error())
+				continue
+			}
+
+			fi, err = file.Stat(u)
+			if err != nil {
+				fmt.Printf("%s: error retrieving metadata: %s\n",
+					u.String(), err.Error())
+				continue
+			}
+
+			fmt.Printf("%s: %s %d bytes, modified %s\n", u.String(),
+				fi.Mode().String(), fi.Size(), fi.ModTime().String())
+		}
+	case "cp", "mv":
+		var src, dst *url.URL
+		var atomic bool
+
+		if len(args) != 2 {
+			fmt.Printf("Wrong number of arguments to %s (expected "+
+				"source and destination)\n", cmd)
+			os.Exit(1)
+		}
+
+		src, err = url.Parse(args[0])
+		if err != nil {
+			fmt.Printf("%s: Error parsing: %s\n", args[0], err.Error())
+			os.Exit(1)
+		}
+
+		dst, err = url.Parse(args[1])
+		if err != nil {
+			fmt.Printf("%s: Error parsing: %s\n", args[1], err.Error())
+			os.Exit(1)
+		}
+
+		if cmd == "mv" {
+			atomic, err = file.Rename(src, dst)
+		} else {
+			atomic, err = file.Copy(src, dst)
+		}
+		if err != nil {
+			fmt.Printf("%s: error copying to %s: %s\n", src.String(),
+				dst.String(), err.Error())
+			os.Exit(1)
+		}
+
+		if !atomic {
+			fmt.Printf("%s: warning: not copied atomically\n", src.String())
+		}
 	case "write":
 		var wc io.WriteCloser
 		if len(args) != 1 {
@@ -206,7 +299,11 @@ func main() {
 			os.Exit(1)
 		}
 
-		wc, err = file.OpenForWrite(u)
+		if atomicWrite {
+			writeOpts = append(writeOpts, file.WithAtomic())
+		}
+
+		wc, err = file.OpenForWrite(u, writeOpts...)
 		if err != nil {
 			fmt.Printf("%s: error opening: %s\n", u.String(),
 				err.Error())
@@ -225,8 +322,12 @@ func main() {
 		}
 	case "watch":
 		var devnull *os.File
-		var watchers []file.Watcher = make([]file.Watcher, len(args))
-		var watcher file.Watcher
+		var watchers []file.DoneWatcher = make([]file.DoneWatcher, len(args))
+		var watcher file.DoneWatcher
+
+		if watchGlob {
+			watchOpts = append(watchOpts, file.WithGlob())
+		}
 
 		for i, path := range args {
 
@@ -236,7 +337,7 @@ func main() {
 				continue
 			}
 
-			watcher, err = file.Watch(u, echoFileOnChange)
+			watcher, err = file.WatchEvents(u, echoFileOnChange, watchOpts...)
 			if err != nil {
 				fmt.Printf("%s: error watching: %s\n", u.String(),
 					err.Error())
@@ -256,7 +357,10 @@ func main() {
 		devnull.Close()
 
 		for _, watcher = range watchers {
-			watcher.Shutdown()
+			if watcher != nil {
+				watcher.Shutdown()
+				<-watcher.Done()
+			}
 		}
 	default:
 		fmt.Printf("Command not implemented: %s\n", cmd)
@@ -0,0 +1,384 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+)
+
+// Function decoding the configuration "data" into "v", which is a pointer
+// to the configuration type. This matches the signature of json.Unmarshal,
+// and of the Unmarshal functions of most YAML and TOML libraries, so they
+// can be passed to RegisterConfigDecoder directly.
+type ConfigDecoder func(data []byte, v interface{}) error
+
+// Reported by a ConfigLoader when no decoder is known for the file.
+var ErrNoConfigDecoder = errors.New("no decoder for this type of file")
+
+// Reported by a ConfigLoader when the configuration file has been removed.
+// The last good configuration remains in effect.
+var ErrConfigRemoved = errors.New("configuration file removed")
+
+var configDecodersMtx sync.RWMutex
+var configDecoders = map[string]ConfigDecoder{
+	".json":            json.Unmarshal,
+	"application/json": json.Unmarshal,
+	".xml":             xml.Unmarshal,
+	"application/xml":  xml.Unmarshal,
+	"text/xml":         xml.Unmarshal,
+}
+
+// Register "decoder" for configuration files of the type "name", which is
+// either a file name extension including the leading dot, such as ".yaml",
+// or a MIME type, such as "application/yaml". Decoders for JSON and XML are
+// registered by default.
+func RegisterConfigDecoder(name string, decoder ConfigDecoder) {
+	configDecodersMtx.Lock()
+	defer configDecodersMtx.Unlock()
+
+	configDecoders[strings.ToLower(name)] = decoder
+}
+
+// Look up the decoder registered for the type "name".
+func lookupConfigDecoder(name string) ConfigDecoder {
+	configDecodersMtx.RLock()
+	defer configDecodersMtx.RUnlock()
+
+	return configDecoders[strings.ToLower(name)]
+}
+
+// Find the decoder for the configuration file "u". The extension of the
+// file is looked up first, and the MIME type associated with it second.
+func configDecoderFor(u *url.URL) ConfigDecoder {
+	var ext string = path.Ext(u.Path)
+	var mediaType string
+	var decoder ConfigDecoder
+	var err error
+
+	if ext == "" {
+		return nil
+	}
+	if decoder = lookupConfigDecoder(ext); decoder != nil {
+		return decoder
+	}
+	if mediaType, _, err = mime.ParseMediaType(
+		mime.TypeByExtension(ext)); err == nil {
+		return lookupConfigDecoder(mediaType)
+	}
+	return nil
+}
+
+// Options for loading configuration files through a ConfigLoader.
+type ConfigOptions struct {
+	// Registry to load the configuration through. Defaults to the
+	// DefaultRegistry.
+	Registry *Registry
+
+	// Decoder for the configuration. If nil, it is determined from the
+	// ContentType, or from the extension of the file name.
+	Decoder ConfigDecoder
+
+	// MIME type of the configuration file, used to look up the decoder.
+	ContentType string
+
+	// Function checking a decoded configuration, which is only used if it
+	// returns nil. It receives a pointer to the configuration type.
+	Validate func(interface{}) error
+
+	// Options for the underlying watch.
+	WatchOptions []WatchOption
+}
+
+// Function setting an option for a ConfigLoader.
+type ConfigOption func(*ConfigOptions)
+
+// Create a new set of ConfigOptions with the defaults, modified by "opts".
+func NewConfigOptions(opts ...ConfigOption) *ConfigOptions {
+	var ret = &ConfigOptions{
+		Registry: DefaultRegistry,
+	}
+	var opt ConfigOption
+
+	for _, opt = range opts {
+		opt(ret)
+	}
+	return ret
+}
+
+// Load the configuration through "r" instead of the DefaultRegistry.
+func WithConfigRegistry(r *Registry) ConfigOption {
+	return func(o *ConfigOptions) {
+		o.Registry = r
+	}
+}
+
+// Decode the configuration using "decoder", regardless of the file type.
+func WithConfigDecoder(decoder ConfigDecoder) ConfigOption {
+	return func(o *ConfigOptions) {
+		o.Decoder = decoder
+	}
+}
+
+// Decode the configuration as the MIME type "contentType", regardless of
+// the extension of the file name.
+func WithConfigContentType(contentType string) ConfigOption {
+	return func(o *ConfigOptions) {
+		o.ContentType = contentType
+	}
+}
+
+// Check every configuration decoded using "validate"; configurations for
+// which it returns an error are rejected. "validate" must take a pointer to
+// the type of the configuration.
+func WithConfigValidator[T any](validate func(*T) error) ConfigOption {
+	return func(o *ConfigOptions) {
+		o.Validate = func(v interface{}) error {
+			var config *T
+			var ok bool
+
+			if config, ok = v.(*T); !ok {
+				return fmt.Errorf("validator for %T used on %T", config, v)
+			}
+			return validate(config)
+		}
+	}
+}
+
+// Watch the configuration through the given WatchOptions.
+func WithConfigWatchOptions(opts ...WatchOption) ConfigOption {
+	return func(o *ConfigOptions) {
+		o.WatchOptions = append(o.WatchOptions, opts...)
+	}
+}
+
+// Configuration of the type T, loaded from a file and reloaded whenever the
+// file changes. Configurations which can't be read, decoded or validated are
+// reported on ErrChan(), and the last good configuration stays in effect.
+// A ConfigLoader implements DoneWatcher; shutting it down stops the
+// reloading.
+type ConfigLoader[T any] struct {
+	url     *url.URL
+	decoder ConfigDecoder
+	options *ConfigOptions
+	watcher DoneWatcher
+	status  *WatchStatus
+	changes chan *T
+	current atomic.Pointer[T]
+
+	// Serializes loading the configuration.
+	mtx sync.Mutex
+}
+
+// Load the configuration of the type T from "u", and keep reloading it until
+// the ConfigLoader is shut down or "ctx" is done. The file can be on any
+// scheme registered with the registry. It is decoded by the decoder for the
+// extension of the file name, unless specified otherwise by "opts".
+// An error is returned if the initial configuration can't be loaded.
+func NewConfigLoader[T any](ctx context.Context, u *url.URL,
+	opts ...ConfigOption) (*ConfigLoader[T], error) {
+	var ret = &ConfigLoader[T]{
+		url:     u,
+		options: NewConfigOptions(opts...),
+		status:  NewWatchStatus(u),
+		changes: make(chan *T, 1),
+	}
+	var err error
+
+	ret.decoder = ret.options.Decoder
+	if ret.decoder == nil && ret.options.ContentType != "" {
+		ret.decoder = lookupConfigDecoder(ret.options.ContentType)
+	}
+	if ret.decoder == nil && ret.options.ContentType == "" {
+		ret.decoder = configDecoderFor(u)
+	}
+	if ret.decoder == nil {
+		return nil, NewError("decode", u, ErrUnsupported, ErrNoConfigDecoder)
+	}
+
+	ret.watcher, err = ret.options.Registry.WatchEventsContext(ctx, u,
+		ret.handleEvent, ret.options.WatchOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The initial state is usually delivered while setting up the watch,
+	// but that is not guaranteed, e.g. if events are queued.
+	if ret.current.Load() == nil {
+		if err = ret.load(ctx, nil); err != nil {
+			ret.Shutdown()
+			return nil, err
+		}
+	}
+
+	go ret.forwardErrors()
+	return ret, nil
+}
+
+// Get the configuration currently in effect. The configuration returned
+// must not be modified, as it is shared between all callers.
+func (l *ConfigLoader[T]) Get() *T {
+	return l.current.Load()
+}
+
+// Get a channel receiving every new configuration as it takes effect,
+// starting with the initial one. If
+// the channel isn't read from, only the latest configuration is kept.
+func (l *ConfigLoader[T]) Changes() <-chan *T {
+	return l.changes
+}
+
+// Reload the configuration on every change reported by the watcher.
+func (l *ConfigLoader[T]) handleEvent(ev *Event) {
+	var err error
+
+	if ev.Err != nil {
+		err = ev.Err
+	} else if ev.Op&(OpDelete|OpRename) != 0 {
+		err = NewError("load", ev.URL, ErrNotExist, ErrConfigRemoved)
+	} else {
+		err = l.load(context.Background(), ev)
+	}
+
+	if err != nil {
+		l.status.Report(err)
+	}
+}
+
+// Read, decode and validate the configuration, from "ev" if given or from
+// the file otherwise, and put it into effect.
+func (l *ConfigLoader[T]) load(ctx context.Context, ev *Event) error {
+	var config *T = new(T)
+	var data []byte
+	var err error
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if ev != nil {
+		data, err = readEvent(ev)
+	} else {
+		data, err = l.read(ctx)
+	}
+	if err != nil {
+		return WrapError("load", l.url, err)
+	}
+
+	if err = l.decoder(data, config); err != nil {
+		return NewError("decode", l.url, nil, err)
+	}
+	if l.options.Validate != nil {
+		if err = l.options.Validate(config); err != nil {
+			return NewError("validate", l.url, nil, err)
+		}
+	}
+
+	l.current.Store(config)
+
+	// Replace any configuration which hasn't been picked up yet.
+	select {
+	case <-l.changes:
+	default:
+	}
+	l.changes <- config
+	return nil
+}
+
+// Read the contents of the changed file reported by "ev".
+func readEvent(ev *Event) ([]byte, error) {
+	var rc io.ReadCloser
+	var err error
+
+	if rc, err = ev.Open(); err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(rc)
+}
+
+// Read the contents of the configuration file.
+func (l *ConfigLoader[T]) read(ctx context.Context) ([]byte, error) {
+	var rc io.ReadCloser
+	var err error
+
+	if rc, err = l.options.Registry.OpenContext(ctx, l.url); err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return ioutil.ReadAll(rc)
+}
+
+// Pass all errors of the underlying watcher on until it has stopped, and
+// mark the loader as stopped then.
+func (l *ConfigLoader[T]) forwardErrors() {
+	var err error
+
+	for err = range l.watcher.ErrChan() {
+		l.status.Report(err)
+	}
+
+	<-l.watcher.Done()
+	l.status.Close()
+}
+
+// Stop reloading the configuration. The last configuration loaded stays
+// available through Get().
+func (l *ConfigLoader[T]) Shutdown() error {
+	return l.watcher.Shutdown()
+}
+
+// Retrieve the error channel of the loader. It receives all errors reading,
+// decoding or validating the configuration, as well as those reported by
+// the underlying watcher. Errors which occur while it is full are dropped.
+func (l *ConfigLoader[T]) ErrChan() chan error {
+	return l.status.ErrChan()
+}
+
+// Retrieve a channel which is closed once the loader has stopped reloading
+// the configuration.
+func (l *ConfigLoader[T]) Done() <-chan struct{} {
+	return l.status.Done()
+}
@@ -0,0 +1,181 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"syscall"
+)
+
+// Kinds of errors which can be returned by file system operations. All
+// file system backends map their native errors onto these, so callers can
+// check for them using errors.Is() regardless of the backend in use. The
+// first three are the same as the ones in io/fs, so checking for e.g.
+// fs.ErrNotExist works just as well.
+var (
+	// The file doesn't exist.
+	ErrNotExist = fs.ErrNotExist
+
+	// The file already exists.
+	ErrExist = fs.ErrExist
+
+	// Permission to access the file was denied.
+	ErrPermission = fs.ErrPermission
+
+	// A directory was expected, but the URL points to something else.
+	ErrNotDir = errors.New("not a directory")
+
+	// The file is too large to be stored by the backend.
+	ErrTooLarge = errors.New("file too large")
+
+	// A condition for writing the file, such as WithIfAbsent, doesn't
+	// hold.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// The operation is not supported by the backend. This is the same as
+	// FS_OperationNotImplementedError.
+	ErrUnsupported = FS_OperationNotImplementedError
+)
+
+// Type of FS_OperationNotImplementedError. It is also considered to be an
+// errors.ErrUnsupported by errors.Is().
+type unsupportedError struct{}
+
+// Description of the error.
+func (unsupportedError) Error() string {
+	return "Operation not implemented for this file system"
+}
+
+// Report whether "target" is errors.ErrUnsupported.
+func (unsupportedError) Is(target error) bool {
+	return target == errors.ErrUnsupported
+}
+
+// Error describing a failed operation on a file in a backend-neutral way.
+// It wraps both the kind of the error (one of the Err* values above) and
+// the error originally reported by the backend, so errors.Is() and
+// errors.As() will find either of them.
+type Error struct {
+	// Operation which failed, e.g. "open" or "remove".
+	Op string
+
+	// URL of the file the operation failed on.
+	URL *url.URL
+
+	// Kind of the error, one of the Err* values, or nil if the error
+	// could not be classified.
+	Kind error
+
+	// The error reported by the backend, or nil if there was none.
+	Err error
+}
+
+// Create a new Error for the operation "op" on the file "u", of the kind
+// "kind". The error "err" reported by the backend, if any, is wrapped.
+func NewError(op string, u *url.URL, kind, err error) error {
+	return &Error{
+		Op:   op,
+		URL:  u,
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// Wrap the error "err" reported by a backend for the operation "op" on the
+// file "u" into an Error, determining its kind by looking at the error
+// itself. Errors which are already of type *Error are returned as-is, and
+// nil is returned if "err" is nil.
+func WrapError(op string, u *url.URL, err error) error {
+	var fserr *Error
+
+	if err == nil {
+		return nil
+	}
+	if errors.As(err, &fserr) {
+		return err
+	}
+	return NewError(op, u, ClassifyError(err), err)
+}
+
+// Determine the kind of the error "err" by looking at the errors it wraps,
+// including system call error numbers. Returns one of the Err* values, or
+// nil if the error doesn't match any of them.
+func ClassifyError(err error) error {
+	switch {
+	case errors.Is(err, ErrNotExist):
+		return ErrNotExist
+	case errors.Is(err, ErrExist):
+		return ErrExist
+	case errors.Is(err, ErrPermission):
+		return ErrPermission
+	case errors.Is(err, ErrNotDir), errors.Is(err, syscall.ENOTDIR):
+		return ErrNotDir
+	case errors.Is(err, ErrTooLarge), errors.Is(err, syscall.EFBIG):
+		return ErrTooLarge
+	case errors.Is(err, ErrPreconditionFailed):
+		return ErrPreconditionFailed
+	case errors.Is(err, ErrUnsupported), errors.Is(err, errors.ErrUnsupported):
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// Describe the error, e.g. "open file:///foo: file does not exist".
+func (e *Error) Error() string {
+	var ret string = e.Op
+
+	if e.URL != nil {
+		ret += " " + e.URL.String()
+	}
+	if e.Err != nil {
+		return ret + ": " + e.Err.Error()
+	}
+	if e.Kind != nil {
+		return ret + ": " + e.Kind.Error()
+	}
+	return ret + ": unknown error"
+}
+
+// Return the kind of the error and the backend error wrapped by it.
+func (e *Error) Unwrap() []error {
+	var ret []error
+
+	if e.Kind != nil {
+		ret = append(ret, e.Kind)
+	}
+	if e.Err != nil {
+		ret = append(ret, e.Err)
+	}
+	return ret
+}
@@ -0,0 +1,284 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/caoimhechaos/go-file"
+	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
+)
+
+// Files too large to be stored in a single key are split into chunks,
+// which are stored in keys below the file's key, in a directory of this
+// name. Each version of the file has its chunks in a subdirectory of its
+// own, named by its generation. The file's key itself holds a manifest
+// describing the chunks. Keys in directories of this name are reserved for
+// chunks, and are not reported by List, Glob or watches.
+const chunkDir = "/.chunks/"
+
+// Marker at the start of the value of a key holding a manifest. Files
+// whose contents start with it are always stored in chunks, so they can't
+// be mistaken for manifests.
+const manifestMagic = "\x00go-file chunked\n"
+
+// Description of a file stored in chunks.
+type chunkManifest struct {
+	// Total size of the file.
+	Size int64 `json:"size"`
+
+	// Number of chunks the file is split into.
+	Chunks int `json:"chunks"`
+
+	// Random name of the directory holding the chunks of this version of
+	// the file, so they don't overwrite the chunks of the version which
+	// can still be read until the manifest is replaced.
+	Generation string `json:"generation"`
+}
+
+// Get the prefix of the keys holding the chunks of the file "path".
+func chunkPrefix(path string) string {
+	return path + chunkDir
+}
+
+// Get the prefix of the keys holding the chunks of the generation "gen" of
+// the file "path".
+func generationPrefix(path, gen string) string {
+	return chunkPrefix(path) + gen + "/"
+}
+
+// Get the key holding the chunk number "n" of the generation "gen" of the
+// file "path". Chunk numbers are padded, so the keys sort in order.
+func chunkKey(path, gen string, n int) string {
+	return fmt.Sprintf("%s%08d", generationPrefix(path, gen), n)
+}
+
+// Pick a name for a new generation of chunks.
+func newGeneration() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
+
+// Determine whether "key" holds a chunk of a file, rather than a file.
+func isChunkKey(key string) bool {
+	return strings.Contains(key, chunkDir)
+}
+
+// Parse "value" as a manifest. Returns nil if it isn't one.
+func parseManifest(value []byte) *chunkManifest {
+	var ret chunkManifest
+
+	if !bytes.HasPrefix(value, []byte(manifestMagic)) {
+		return nil
+	}
+	if json.Unmarshal(value[len(manifestMagic):], &ret) != nil {
+		return nil
+	}
+	return &ret
+}
+
+// Encode "m" as the value of a manifest key.
+func (m *chunkManifest) encode() string {
+	var data []byte
+
+	// Encoding a struct of integers can't fail.
+	data, _ = json.Marshal(m)
+	return manifestMagic + string(data)
+}
+
+// Get the size of the file stored in the key "kv".
+func valueSize(kv *mvccpb.KeyValue) int64 {
+	var m *chunkManifest = parseManifest(kv.Value)
+
+	if m != nil {
+		return m.Size
+	}
+	return int64(len(kv.Value))
+}
+
+// Get the contents of the file stored in the key "kv", which was read at
+// the revision "rev". If it holds a manifest, the chunks are read at the
+// same revision and put back together.
+func readValue(ctx context.Context, etcdClient *etcd.Client,
+	kv *mvccpb.KeyValue, rev int64) ([]byte, error) {
+	var m *chunkManifest = parseManifest(kv.Value)
+	var path string = string(kv.Key)
+	var resp *etcd.GetResponse
+	var buf *bytes.Buffer
+	var chunk *mvccpb.KeyValue
+	var n int
+	var err error
+
+	if m == nil {
+		return kv.Value, nil
+	}
+
+	resp, err = etcdClient.Get(ctx, generationPrefix(path, m.Generation),
+		etcd.WithPrefix(), etcd.WithRev(rev),
+		etcd.WithSort(etcd.SortByKey, etcd.SortAscend))
+	if err != nil {
+		return nil, etcdError("read", path, err)
+	}
+
+	buf = bytes.NewBuffer(make([]byte, 0, m.Size))
+	for n, chunk = range resp.Kvs {
+		if n >= m.Chunks ||
+			string(chunk.Key) != chunkKey(path, m.Generation, n) {
+			break
+		}
+		buf.Write(chunk.Value)
+	}
+
+	if len(resp.Kvs) != m.Chunks || int64(buf.Len()) != m.Size {
+		return nil, file.NewError("read", etcdURL(path), nil,
+			fmt.Errorf("chunks don't match manifest: %d chunks of %d "+
+				"bytes, expected %d of %d bytes", len(resp.Kvs),
+				buf.Len(), m.Chunks, m.Size))
+	}
+	return buf.Bytes(), nil
+}
+
+// Store "data" as the file "path" in chunks of at most "chunkSize" bytes.
+// Each chunk is written in a request of its own, so the file as a whole
+// may be larger than etcd allows for a single request. The chunks go into
+// a new generation, so readers keep seeing the previous version of the
+// file until the manifest is replaced. This is done in a transaction which
+// only succeeds if "cmps" hold, and which also removes the chunks of all
+// other generations, including those left behind by failed writes. If the
+// transaction fails, the new chunks are removed again, and an error of the
+// kind file.ErrPreconditionFailed is returned. "opts" are applied to all
+// keys written, e.g. to attach them to a lease.
+//
+// A concurrent write of the same file may remove the new chunks before the
+// manifest has been written; in that case, the chunks are written again.
+func putChunked(ctx context.Context, etcdClient *etcd.Client, path string,
+	data []byte, chunkSize int, cmps []etcd.Cmp,
+	opts ...etcd.OpOption) error {
+	var m *chunkManifest
+	var resp *etcd.TxnResponse
+	var first int64
+	var err error
+
+	for {
+		var prefix string
+		var check []etcd.Cmp
+
+		m = &chunkManifest{
+			Size:       int64(len(data)),
+			Generation: newGeneration(),
+		}
+		prefix = generationPrefix(path, m.Generation)
+
+		first, err = putChunks(ctx, etcdClient, path, data, chunkSize,
+			m, opts...)
+		if err == nil {
+			// The chunks are written in order, so if the first
+			// one is still there, all others are as well.
+			check = append(check, cmps...)
+			check = append(check, etcd.Compare(etcd.ModRevision(
+				chunkKey(path, m.Generation, 0)), "=", first))
+
+			resp, err = etcdClient.Txn(ctx).If(check...).Then(
+				etcd.OpDelete(chunkPrefix(path),
+					etcd.WithRange(prefix)),
+				etcd.OpDelete(etcd.GetPrefixRangeEnd(prefix),
+					etcd.WithRange(etcd.GetPrefixRangeEnd(
+						chunkPrefix(path)))),
+				etcd.OpPut(path, m.encode(), opts...),
+			).Else(
+				etcd.OpGet(chunkKey(path, m.Generation, 0),
+					etcd.WithKeysOnly()),
+			).Commit()
+		}
+		if err == nil && resp.Succeeded {
+			return nil
+		}
+
+		// Don't leave the chunks behind, even if "ctx" is done.
+		etcdClient.Delete(etcdClient.Ctx(), prefix, etcd.WithPrefix())
+
+		if err != nil {
+			return err
+		}
+		if len(resp.Responses[0].GetResponseRange().Kvs) > 0 {
+			return file.NewError("write", etcdURL(path),
+				file.ErrPreconditionFailed, nil)
+		}
+	}
+}
+
+// Write "data" as the chunks of the file "path" described by "m", filling
+// in the number of chunks. Returns the revision the first chunk was
+// written at.
+func putChunks(ctx context.Context, etcdClient *etcd.Client, path string,
+	data []byte, chunkSize int, m *chunkManifest,
+	opts ...etcd.OpOption) (int64, error) {
+	var resp *etcd.PutResponse
+	var first int64
+	var end int
+	var err error
+
+	for len(data) > 0 {
+		end = chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		resp, err = etcdClient.Put(ctx, chunkKey(path, m.Generation,
+			m.Chunks), string(data[:end]), opts...)
+		if err != nil {
+			return 0, err
+		}
+		if m.Chunks == 0 {
+			first = resp.Header.Revision
+		}
+
+		data = data[end:]
+		m.Chunks++
+	}
+	return first, nil
+}
+
+// Get the operations storing "data" as the file "path" in a single key,
+// and removing the chunks of a previous version of the file, if any. "opts"
+// are applied to the key written.
+func plainPutOps(path string, data []byte, opts ...etcd.OpOption) []etcd.Op {
+	return []etcd.Op{
+		etcd.OpDelete(chunkPrefix(path), etcd.WithPrefix()),
+		etcd.OpPut(path, string(data), opts...),
+	}
+}
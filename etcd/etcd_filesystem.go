@@ -34,10 +34,16 @@ package etcd
 import (
 	"io"
 	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/caoimhechaos/go-file"
 	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	"golang.org/x/net/context"
 )
@@ -45,29 +51,202 @@ import (
 // etcd file system implementation.
 type etcdFileSystem struct {
 	etcdClient *etcd.Client
+	options    *EtcdOptions
 }
 
-// Register the etcd watcher with the go-file mechanisms.
-func RegisterEtcdClient(etcdClient *etcd.Client) {
+// Settings for storing files in etcd, which can be given when registering
+// a client.
+type EtcdOptions struct {
+	// Largest file which can be written, in bytes. Writing larger files
+	// fails with an error of the kind file.ErrTooLarge. Zero or less
+	// means there is no limit. Defaults to MAX_FILE_LEN.
+	MaxFileSize int64
+
+	// Files larger than this many bytes are split into chunks of this
+	// size, which are stored in separate keys. Each chunk is written in
+	// a request of its own, so it must fit into a single etcd request,
+	// but the file as a whole may exceed etcd's request size limit; use
+	// WithMaxFileSize to raise the size limit accordingly. The manifest
+	// describing the chunks is written last, so the new contents become
+	// visible at once. Zero or less, the default, disables chunking.
+	ChunkSize int
+}
+
+// Function setting an option for storing files in etcd.
+type EtcdOption func(*EtcdOptions)
+
+// Create a new set of EtcdOptions with the defaults, modified by "opts".
+func NewEtcdOptions(opts ...EtcdOption) *EtcdOptions {
+	var ret = &EtcdOptions{
+		MaxFileSize: MAX_FILE_LEN,
+	}
+	var opt EtcdOption
+
+	for _, opt = range opts {
+		opt(ret)
+	}
+	return ret
+}
+
+// Limit the size of files written to "size" bytes. Since etcd limits the
+// size of requests, this is usually combined with WithChunkSize.
+func WithMaxFileSize(size int64) EtcdOption {
+	return func(o *EtcdOptions) {
+		o.MaxFileSize = size
+	}
+}
+
+// Store files larger than "size" bytes in chunks of that size, each of
+// which is written in a separate request. "size" must be small enough for
+// a chunk to fit into a single etcd request.
+func WithChunkSize(size int) EtcdOption {
+	return func(o *EtcdOptions) {
+		o.ChunkSize = size
+	}
+}
+
+// etcd specific details about a key, as returned by the Sys() method of
+// the FileInfo returned by Stat.
+type EtcdFileInfo struct {
+	// Revision at which the key was created.
+	CreateRevision int64
+
+	// Revision at which the key was last modified.
+	ModRevision int64
+
+	// Number of times the key has been modified since its creation.
+	Version int64
+
+	// ID of the lease attached to the key, or 0.
+	Lease int64
+}
+
+// Describe the version of the key by its ModRevision, for use with
+// file.WithIfMatch.
+func (fi *EtcdFileInfo) ETag() string {
+	return strconv.FormatInt(fi.ModRevision, 10)
+}
+
+// Build an etcd URL referring to the key "path", e.g. for error messages.
+func etcdURL(path string) *url.URL {
+	return &url.URL{Scheme: "etcd", Path: path}
+}
+
+// Map the error "err" returned by the etcd client for the operation "op" on
+// the key "path" onto the go-file error kinds.
+func etcdError(op, path string, err error) error {
+	var kind error
+
+	switch err {
+	case nil:
+		return nil
+	case rpctypes.ErrKeyNotFound:
+		kind = file.ErrNotExist
+	case rpctypes.ErrPermissionDenied, rpctypes.ErrPermissionNotGranted,
+		rpctypes.ErrAuthFailed, rpctypes.ErrInvalidAuthToken:
+		kind = file.ErrPermission
+	case rpctypes.ErrRequestTooLarge:
+		kind = file.ErrTooLarge
+	default:
+		return file.WrapError(op, etcdURL(path), err)
+	}
+
+	return file.NewError(op, etcdURL(path), kind, err)
+}
+
+// Register the etcd watcher with the go-file mechanisms. This can be
+// invoked again at any time to replace the client in use, e.g. to rotate
+// credentials; the previously registered client is not closed. Files are
+// stored as specified by "opts".
+func RegisterEtcdClient(etcdClient *etcd.Client, opts ...EtcdOption) {
+	RegisterEtcdClientWithRegistry(file.DefaultRegistry, etcdClient, opts...)
+}
+
+// Register the etcd watcher with the registry "registry", so etcd:// URLs
+// in that registry will be handled by "etcdClient". This allows using
+// different etcd clusters, or different settings, in different registries.
+func RegisterEtcdClientWithRegistry(registry *file.Registry,
+	etcdClient *etcd.Client, opts ...EtcdOption) {
 	var watcherCreator = &EtcdWatcherCreator{
 		etcdClient: etcdClient,
 	}
-	var fs = &etcdFileSystem{
+	registry.RegisterScheme("etcd", NewEtcdFileSystem(etcdClient, opts...),
+		watcherCreator)
+}
+
+// Create a file system for etcd:// URLs handled by "etcdClient", storing
+// files as specified by "opts", without registering it anywhere. This is
+// useful e.g. for running the fstest conformance tests against etcd.
+func NewEtcdFileSystem(etcdClient *etcd.Client,
+	opts ...EtcdOption) file.FileSystem {
+	return &etcdFileSystem{
 		etcdClient: etcdClient,
+		options:    NewEtcdOptions(opts...),
 	}
-	file.RegisterWatcher("etcd", watcherCreator)
-	file.RegisterFileSystem("etcd", fs)
 }
 
 // Open the file given as "u" for reading.
 func (e *etcdFileSystem) Open(u *url.URL) (io.ReadCloser, error) {
-	return NewEtcdReader(e.etcdClient, u.Path)
+	return e.OpenContext(context.Background(), u)
+}
+
+// Open the file given as "u" for reading. The contents will be fetched
+// using "ctx" once the file is first read. The reader returned is an
+// *EtcdReader, which also implements io.Seeker and io.ReaderAt.
+func (e *etcdFileSystem) OpenContext(ctx context.Context, u *url.URL) (
+	io.ReadCloser, error) {
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return NewEtcdReaderContext(ctx, e.etcdClient, u.Path)
 }
 
 // Open the file given as "u" for writing. Any data written to "u"
 // will only actually be written to Doozer when Close() is invoked.
 func (e *etcdFileSystem) OpenForWrite(u *url.URL) (io.WriteCloser, error) {
-	return NewEtcdWriter(e.etcdClient, u.Path), nil
+	return e.OpenForWriteContext(context.Background(), u)
+}
+
+// Open the file given as "u" for writing. The data will be written to
+// etcd using "ctx" when Close() is invoked, in chunks if it is larger than
+// the chunk size configured.
+func (e *etcdFileSystem) OpenForWriteContext(ctx context.Context,
+	u *url.URL) (io.WriteCloser, error) {
+	return e.OpenForWriteOptionsContext(ctx, u, file.NewWriteOptions())
+}
+
+// Open the file given as "u" for writing like OpenForWriteContext. The
+// conditions in "opts" are checked as part of the transaction writing the
+// file when Close() is invoked. Since that transaction replaces the file
+// atomically, the Atomic option makes no difference.
+//
+// Files written with a TTL, given either in "opts" or as the "ttl" query
+// parameter of "u" (e.g. "?ttl=30s" or "?ttl=30"), are attached to a lease
+// which is kept alive until it is released through the Lease() method of
+// the *EtcdWriter returned.
+func (e *etcdFileSystem) OpenForWriteOptionsContext(ctx context.Context,
+	u *url.URL, opts *file.WriteOptions) (io.WriteCloser, error) {
+	var wr *EtcdWriter
+	var ttl string = u.Query().Get("ttl")
+	var withTTL file.WriteOptions
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if ttl != "" && opts.TTL == 0 {
+		withTTL = *opts
+		if withTTL.TTL, err = parseTTL(ttl); err != nil {
+			return nil, file.NewError("write", u, nil, err)
+		}
+		opts = &withTTL
+	}
+	wr = NewEtcdWriterWithOptions(ctx, e.etcdClient, u.Path, e.options)
+	wr.conditions = opts
+	return wr, nil
 }
 
 // Open the file given as "u" for appending. This may be implemented
@@ -76,11 +255,27 @@ func (e *etcdFileSystem) OpenForAppend(u *url.URL) (io.WriteCloser, error) {
 	return nil, file.FS_OperationNotImplementedError
 }
 
+// Appending is not implemented for etcd, regardless of the context.
+func (e *etcdFileSystem) OpenForAppendContext(ctx context.Context,
+	u *url.URL) (io.WriteCloser, error) {
+	return nil, file.FS_OperationNotImplementedError
+}
+
+// Get a list of all names under "u", which is supposed to be a directory.
+func (e *etcdFileSystem) List(u *url.URL) ([]string, error) {
+	return e.ListContext(context.Background(), u)
+}
+
 // Get a list of all names under "u", which is supposed to be a directory.
-func (e *etcdFileSystem) List(u *url.URL) (ret []string, err error) {
+// Like directory listings on other file systems, only the short names of
+// the entries directly below "u" are returned; keys further down the tree
+// are reported by the name of the subdirectory containing them.
+// The request to etcd will be aborted once "ctx" is done.
+func (e *etcdFileSystem) ListContext(ctx context.Context, u *url.URL) (
+	ret []string, err error) {
 	var resp *etcd.GetResponse
-	var ctx context.Context = context.Background()
 	var prefix string = u.Path
+	var seen map[string]bool = make(map[string]bool)
 	var kv *mvccpb.KeyValue
 
 	// Make sure the prefix is slash delimited.
@@ -90,29 +285,245 @@ func (e *etcdFileSystem) List(u *url.URL) (ret []string, err error) {
 
 	// Now, get all keys which start with the slash-terminated prefix.
 	resp, err = e.etcdClient.Get(
-		ctx, u.Path, etcd.WithPrefix(), etcd.WithKeysOnly())
+		ctx, prefix, etcd.WithPrefix(), etcd.WithKeysOnly(),
+		etcd.WithSort(etcd.SortByKey, etcd.SortAscend))
 	if err != nil {
+		err = etcdError("list", u.Path, err)
 		return
 	}
 
 	for _, kv = range resp.Kvs {
-		ret = append(ret, string(kv.Key))
+		var name string = strings.TrimPrefix(string(kv.Key), prefix)
+
+		if isChunkKey(string(kv.Key)) {
+			continue
+		}
+
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i]
+		}
+		if len(name) == 0 || seen[name] {
+			continue
+		}
+		seen[name] = true
+		ret = append(ret, name)
 	}
 	return
 }
 
+// Find all keys matching the glob pattern "pattern", as well as all
+// directories implied by them which match it. Only the keys starting with
+// the literal prefix of the pattern are fetched from etcd, in a single
+// keys-only request which will be aborted once "ctx" is done.
+func (e *etcdFileSystem) GlobContext(ctx context.Context, pattern *url.URL) (
+	[]*url.URL, error) {
+	var resp *etcd.GetResponse
+	var kv *mvccpb.KeyValue
+	var seen map[string]bool = make(map[string]bool)
+	var ret []*url.URL
+	var err error
+
+	resp, err = e.etcdClient.Get(
+		ctx, file.PatternPrefix(pattern.Path), etcd.WithPrefix(),
+		etcd.WithKeysOnly(), etcd.WithSort(etcd.SortByKey, etcd.SortAscend))
+	if err != nil {
+		return nil, etcdError("glob", pattern.Path, err)
+	}
+
+	for _, kv = range resp.Kvs {
+		var key string = string(kv.Key)
+		var i int
+
+		if isChunkKey(key) {
+			continue
+		}
+
+		// Check the key itself as well as all of its parent directories.
+		for i = 1; i <= len(key); i++ {
+			var name string
+
+			if i < len(key) && key[i] != '/' {
+				continue
+			}
+			name = key[:i]
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			if ok, _ := file.MatchPattern(pattern.Path, name); ok {
+				var u url.URL = *pattern
+
+				u.Path = name
+				ret = append(ret, &u)
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].String() < ret[j].String()
+	})
+	return ret, nil
+}
+
 // Create a new watcher object for watching for notifications on the
 // given URL.
 func (e *etcdFileSystem) Watch(u *url.URL,
 	cb func(string, io.ReadCloser)) (file.Watcher, error) {
-	return NewEtcdWatcher(e.etcdClient, u.Path, cb)
+	return NewEtcdWatcher(e.etcdClient, watchPath(u), cb)
+}
+
+// Create a new watcher object for watching for notifications on the
+// given URL until "ctx" is done.
+func (e *etcdFileSystem) WatchContext(ctx context.Context, u *url.URL,
+	cb func(string, io.ReadCloser)) (file.Watcher, error) {
+	return NewEtcdWatcherContext(ctx, e.etcdClient, watchPath(u), cb)
+}
+
+// Create a new watcher object delivering Events for changes of the given
+// URL until "ctx" is done.
+func (e *etcdFileSystem) WatchEventsContext(ctx context.Context, u *url.URL,
+	handler func(*file.Event)) (file.Watcher, error) {
+	return NewEtcdEventWatcher(ctx, e.etcdClient, watchPath(u), handler)
 }
 
 // Remove deletes the specified object from the etcd tree.
 func (e *etcdFileSystem) Remove(u *url.URL) error {
-	var ctx context.Context = context.Background()
+	return e.RemoveContext(context.Background(), u)
+}
+
+// Remove deletes the specified object from the etcd tree, along with its
+// chunks if it is stored in chunks. The request to etcd will be aborted
+// once "ctx" is done.
+func (e *etcdFileSystem) RemoveContext(ctx context.Context, u *url.URL) error {
+	var resp *etcd.TxnResponse
 	var err error
 
-	_, err = e.etcdClient.Delete(ctx, u.Path)
-	return err
+	resp, err = e.etcdClient.Txn(ctx).Then(
+		etcd.OpDelete(u.Path),
+		etcd.OpDelete(chunkPrefix(u.Path), etcd.WithPrefix()),
+	).Commit()
+	if err != nil {
+		return etcdError("remove", u.Path, err)
+	}
+
+	if resp.Responses[0].GetResponseDeleteRange().Deleted == 0 {
+		return file.NewError("remove", u, file.ErrNotExist, nil)
+	}
+	return nil
+}
+
+// Retrieve metadata about the key given as "u". The key is fetched along
+// with its value, since etcd can't report the size of a value otherwise;
+// for files stored in chunks, only the manifest is fetched. Since etcd
+// doesn't have directories, "u" is reported as a directory if it doesn't
+// exist itself but there are keys below it, which is determined by a
+// keys-only Get. etcd doesn't keep track of modification times, so the
+// modification time is always zero. The Sys() method of the result returns
+// an *EtcdFileInfo for keys.
+func (e *etcdFileSystem) StatContext(ctx context.Context, u *url.URL) (
+	os.FileInfo, error) {
+	var resp *etcd.GetResponse
+	var kv *mvccpb.KeyValue
+	var prefix string = u.Path
+	var err error
+
+	// The size is taken from the value, or from the manifest for files
+	// stored in chunks. Unchunked values can be up to MaxFileSize bytes.
+	resp, err = e.etcdClient.Get(ctx, u.Path)
+	if err != nil {
+		return nil, etcdError("stat", u.Path, err)
+	}
+
+	for _, kv = range resp.Kvs {
+		return file.NewFileInfo(path.Base(string(kv.Key)),
+			valueSize(kv), 0644, time.Time{}, &EtcdFileInfo{
+				CreateRevision: kv.CreateRevision,
+				ModRevision:    kv.ModRevision,
+				Version:        kv.Version,
+				Lease:          kv.Lease,
+			}), nil
+	}
+
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	resp, err = e.etcdClient.Get(ctx, prefix, etcd.WithPrefix(),
+		etcd.WithKeysOnly(), etcd.WithLimit(1))
+	if err != nil {
+		return nil, etcdError("stat", prefix, err)
+	}
+
+	if len(resp.Kvs) > 0 {
+		return file.NewFileInfo(path.Base(u.Path), 0, os.ModeDir|0755,
+			time.Time{}, nil), nil
+	}
+
+	return nil, file.NewError("stat", u, file.ErrNotExist, nil)
+}
+
+// Rename the key "src" to "dst". This is done in a single transaction which
+// puts the contents of "src" into "dst" and deletes "src", so the rename is
+// atomic.
+func (e *etcdFileSystem) RenameContext(ctx context.Context,
+	src, dst *url.URL) error {
+	return e.copyKey(ctx, "rename", src.Path, dst.Path, true)
+}
+
+// Copy the contents of the key "src" to "dst" in a single transaction.
+func (e *etcdFileSystem) CopyContext(ctx context.Context,
+	src, dst *url.URL) error {
+	return e.copyKey(ctx, "copy", src.Path, dst.Path, false)
+}
+
+// Copy the contents of the key "src" to "dst", and remove "src" in the same
+// transaction if "remove" is set. The transaction only succeeds if "src"
+// hasn't been modified since it was read; otherwise, the whole process is
+// retried.
+func (e *etcdFileSystem) copyKey(ctx context.Context, op, src, dst string,
+	remove bool) error {
+	var resp *etcd.GetResponse
+	var txnResp *etcd.TxnResponse
+	var kv *mvccpb.KeyValue
+	var ops []etcd.Op
+	var err error
+
+	for {
+		resp, err = e.etcdClient.Get(ctx, src)
+		if err != nil {
+			return etcdError(op, src, err)
+		}
+
+		if len(resp.Kvs) == 0 {
+			return file.NewError(op, etcdURL(src), file.ErrNotExist, nil)
+		}
+		if src == dst {
+			// Copying or renaming an existing key onto itself
+			// leaves it alone.
+			return nil
+		}
+		kv = resp.Kvs[0]
+
+		// Files stored in chunks are copied by streaming them.
+		if parseManifest(kv.Value) != nil {
+			return file.FS_OperationNotImplementedError
+		}
+
+		ops = plainPutOps(dst, kv.Value)
+		if remove {
+			ops = append(ops, etcd.OpDelete(src))
+		}
+
+		txnResp, err = e.etcdClient.Txn(ctx).If(
+			etcd.Compare(etcd.ModRevision(src), "=", kv.ModRevision),
+		).Then(ops...).Commit()
+		if err != nil {
+			return etcdError(op, dst, err)
+		}
+
+		if txnResp.Succeeded {
+			return nil
+		}
+	}
 }
@@ -0,0 +1,93 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package etcd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caoimhechaos/go-file/fstest"
+	etcd "github.com/coreos/etcd/clientv3"
+)
+
+// Environment variable holding the comma separated client URLs of the etcd
+// cluster to run the tests against. The tests are skipped if it isn't set.
+const endpointsVar = "ETCD_ENDPOINTS"
+
+// How long to wait for the connection to etcd.
+const dialTimeout = 10 * time.Second
+
+// Connect to the etcd cluster named in $ETCD_ENDPOINTS, skipping the test
+// if there is none. The client is closed at the end of the test.
+func connectEtcd(t *testing.T) *etcd.Client {
+	var endpoints string = os.Getenv(endpointsVar)
+	var client *etcd.Client
+	var err error
+
+	t.Helper()
+
+	if endpoints == "" {
+		t.Skipf("$%s not set, no etcd cluster to test against",
+			endpointsVar)
+	}
+
+	client, err = etcd.New(etcd.Config{
+		Endpoints:   strings.Split(endpoints, ","),
+		DialTimeout: dialTimeout,
+	})
+	if err != nil {
+		t.Fatalf("Connecting to etcd at %s: %s", endpoints, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Run the conformance tests against an etcd cluster, once storing files in
+// single keys and once splitting them into tiny chunks. All keys are kept
+// below a prefix unique to the test run.
+func TestConformance(t *testing.T) {
+	var client *etcd.Client = connectEtcd(t)
+	var base string = fmt.Sprintf("/go-file-test/%d", time.Now().UnixNano())
+
+	t.Run("Plain", func(t *testing.T) {
+		fstest.TestFileSystem(t, NewEtcdFileSystem(client),
+			&url.URL{Scheme: "etcd", Path: base + "/plain"})
+	})
+	t.Run("Chunked", func(t *testing.T) {
+		fstest.TestFileSystem(t, NewEtcdFileSystem(client, WithChunkSize(4)),
+			&url.URL{Scheme: "etcd", Path: base + "/chunked"})
+	})
+}
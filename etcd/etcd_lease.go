@@ -0,0 +1,150 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package etcd
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"golang.org/x/net/context"
+)
+
+// Lease attached to a file written to etcd with a TTL, e.g. for service
+// registration. The lease is kept alive in the background until it is
+// released or the etcd client is closed. Once the process holding it dies
+// or loses its connection to etcd for longer than the TTL, the lease
+// expires and etcd deletes the file, which watchers report as a deletion.
+type EtcdLease struct {
+	etcdClient *etcd.Client
+	path       string
+	id         etcd.LeaseID
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// Convert "ttl" into the number of seconds etcd expects, rounding up so the
+// file never expires early.
+func ttlSeconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// Parse the TTL given in the query of an etcd URL, either as a duration
+// such as "30s" or as a plain number of seconds.
+func parseTTL(ttl string) (time.Duration, error) {
+	var ret time.Duration
+	var secs int64
+	var err error
+
+	if secs, err = strconv.ParseInt(ttl, 10, 64); err == nil {
+		ret = time.Duration(secs) * time.Second
+	} else if ret, err = time.ParseDuration(ttl); err != nil {
+		return 0, err
+	}
+
+	if ret <= 0 {
+		return 0, fmt.Errorf("TTL must be positive, got %s", ttl)
+	}
+	return ret, nil
+}
+
+// Start keeping the lease "id" attached to the file "path" alive.
+func newEtcdLease(etcdClient *etcd.Client, path string, id etcd.LeaseID) (
+	*EtcdLease, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var ch <-chan *etcd.LeaseKeepAliveResponse
+	var ret *EtcdLease
+	var err error
+
+	ctx, cancel = context.WithCancel(etcdClient.Ctx())
+	ch, err = etcdClient.KeepAlive(ctx, id)
+	if err != nil {
+		cancel()
+		return nil, etcdError("write", path, err)
+	}
+
+	ret = &EtcdLease{
+		etcdClient: etcdClient,
+		path:       path,
+		id:         id,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go ret.keepAlive(ch)
+	return ret, nil
+}
+
+// Consume the responses to the keepalive requests until the lease is no
+// longer kept alive, then mark it as done.
+func (l *EtcdLease) keepAlive(ch <-chan *etcd.LeaseKeepAliveResponse) {
+	defer close(l.done)
+
+	for range ch {
+	}
+}
+
+// Get the ID of the lease in etcd, e.g. for attaching further keys to it.
+func (l *EtcdLease) ID() etcd.LeaseID {
+	return l.id
+}
+
+// Get a channel which is closed once the lease is no longer kept alive,
+// because it has been released, has expired or the etcd client has been
+// closed.
+func (l *EtcdLease) Done() <-chan struct{} {
+	return l.done
+}
+
+// Stop keeping the lease alive and revoke it, which deletes the file
+// immediately.
+func (l *EtcdLease) Release() error {
+	return l.ReleaseContext(context.Background())
+}
+
+// Stop keeping the lease alive and revoke it, which deletes the file
+// immediately. If revoking the lease fails, e.g. because "ctx" is done
+// first, the file will still be deleted once the lease expires.
+func (l *EtcdLease) ReleaseContext(ctx context.Context) error {
+	var err error
+
+	l.cancel()
+
+	_, err = l.etcdClient.Revoke(ctx, l.id)
+	if err == rpctypes.ErrLeaseNotFound {
+		// The lease has expired already.
+		return nil
+	}
+	return etcdError("release", l.path, err)
+}
@@ -32,9 +32,11 @@
 package etcd
 
 import (
-	"io"
+	"bytes"
+	"os"
 	"sync"
 
+	"github.com/caoimhechaos/go-file"
 	etcd "github.com/coreos/etcd/clientv3"
 	"github.com/coreos/etcd/mvcc/mvccpb"
 	"golang.org/x/net/context"
@@ -42,54 +44,126 @@ import (
 
 // Reader to read a file from etcd.
 //
-// Upon the first call to Read(), the entire contents of the file are
-// returned. Subsequent calls will return an EOF error.
+// The value of the key is fetched from etcd upon the first call to Read(),
+// ReadAt() or Seek(), so just opening and closing the file is cheap. All
+// reads are then served from the value fetched, like from a bytes.Reader.
+// Files stored in chunks are put back together from the chunks as they
+// were at the time the key was read.
 type EtcdReader struct {
+	ctx        context.Context
 	etcdClient *etcd.Client
 	path       string
-	wasReadMtx sync.Mutex
-	wasRead    bool
+
+	mtx    sync.Mutex
+	reader *bytes.Reader
+	err    error
+	closed bool
 }
 
 // Create a new EtcdReader to read the file "path" from the client "etcdClient".
 func NewEtcdReader(etcdClient *etcd.Client, path string) (*EtcdReader, error) {
+	return NewEtcdReaderContext(context.Background(), etcdClient, path)
+}
+
+// Create a new EtcdReader to read the file "path" from the client "etcdClient".
+// The etcd request made by the first read will be aborted once "ctx" is done.
+func NewEtcdReaderContext(ctx context.Context, etcdClient *etcd.Client,
+	path string) (*EtcdReader, error) {
 	return &EtcdReader{
+		ctx:        ctx,
 		etcdClient: etcdClient,
 		path:       path,
 	}, nil
 }
 
-// Read the contents of the wrapped file and etcd and return them.
-func (rd *EtcdReader) Read(p []byte) (int, error) {
+// Get the reader for the value of the key, fetching it from etcd if that
+// hasn't happened yet. If fetching the value fails, the error is returned
+// by all subsequent calls as well. Must be called with the lock held.
+func (rd *EtcdReader) fetch() (*bytes.Reader, error) {
 	var resp *etcd.GetResponse
 	var kv *mvccpb.KeyValue
-	var ctx context.Context
 	var err error
 
-	rd.wasReadMtx.Lock()
-	defer rd.wasReadMtx.Unlock()
-
-	if rd.wasRead {
-		return 0, io.EOF
+	if rd.closed {
+		return nil, file.NewError("read", etcdURL(rd.path), nil, os.ErrClosed)
+	}
+	if rd.reader != nil || rd.err != nil {
+		return rd.reader, rd.err
 	}
-	rd.wasRead = true
 
-	ctx = context.Background()
-	resp, err = rd.etcdClient.Get(ctx, rd.path)
+	resp, err = rd.etcdClient.Get(rd.ctx, rd.path)
 	if err != nil {
-		return 0, err
+		rd.err = etcdError("read", rd.path, err)
+		return nil, rd.err
 	}
 
 	for _, kv = range resp.Kvs {
-		p = kv.Value
-		return len(p), nil
+		var data []byte
+
+		data, err = readValue(rd.ctx, rd.etcdClient, kv,
+			resp.Header.Revision)
+		if err != nil {
+			rd.err = err
+			return nil, err
+		}
+		rd.reader = bytes.NewReader(data)
+		return rd.reader, nil
+	}
+
+	rd.err = file.NewError("read", etcdURL(rd.path), file.ErrNotExist, nil)
+	return nil, rd.err
+}
+
+// Read the next part of the value of the key into "p".
+func (rd *EtcdReader) Read(p []byte) (int, error) {
+	var reader *bytes.Reader
+	var err error
+
+	rd.mtx.Lock()
+	defer rd.mtx.Unlock()
+
+	if reader, err = rd.fetch(); err != nil {
+		return 0, err
+	}
+	return reader.Read(p)
+}
+
+// Read the part of the value of the key starting at "off" into "p". This
+// doesn't affect the position used by Read().
+func (rd *EtcdReader) ReadAt(p []byte, off int64) (int, error) {
+	var reader *bytes.Reader
+	var err error
+
+	rd.mtx.Lock()
+	defer rd.mtx.Unlock()
+
+	if reader, err = rd.fetch(); err != nil {
+		return 0, err
 	}
+	return reader.ReadAt(p, off)
+}
+
+// Set the position for the next Read() within the value of the key, as
+// described for io.Seeker.
+func (rd *EtcdReader) Seek(offset int64, whence int) (int64, error) {
+	var reader *bytes.Reader
+	var err error
 
-	return 0, io.EOF
+	rd.mtx.Lock()
+	defer rd.mtx.Unlock()
+
+	if reader, err = rd.fetch(); err != nil {
+		return 0, err
+	}
+	return reader.Seek(offset, whence)
 }
 
-// Define the file handle as closed, just in case.
+// Close the reader, releasing the value of the key. Reads after this fail.
 func (rd *EtcdReader) Close() error {
-	rd.wasRead = true
+	rd.mtx.Lock()
+	defer rd.mtx.Unlock()
+
+	rd.closed = true
+	rd.reader = nil
 	return nil
 }
@@ -33,21 +33,56 @@ package etcd
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/url"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/caoimhechaos/go-file"
 	etcd "github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"golang.org/x/net/context"
 )
 
+// How long to wait before re-establishing a watch which has failed.
+const WatchRetryInterval = time.Second
+
+// Reported when the etcd client closes the watch without giving a reason.
+var errWatchClosed = errors.New("watch closed by the etcd client")
+
 // Watcher for an individual etcd key, or prefix.
 type EtcdWatcher struct {
+	ctx        context.Context
 	etcdClient *etcd.Client
 	path       string
-	errchan    chan error
-	shutdown   chan bool
-	cb         func(string, io.ReadCloser)
+	status     *file.WatchStatus
+	cancel     context.CancelFunc
+	handler    func(*file.Event)
+
+	// Whether all keys starting with "path" are watched, rather than
+	// just the key itself.
+	prefix bool
+
+	// Revision to resume watching from, i.e. one after the last revision
+	// delivered.
+	rev int64
+
+	// State of each key currently known to exist, so the changes missed
+	// while revisions were compacted away can be determined, and the
+	// lease of deleted keys can be reported.
+	seen map[string]keyState
+
+	// Whether revisions which haven't been delivered yet were compacted,
+	// so the state of the keys has to be fetched again before resuming.
+	resync bool
+}
+
+// What an EtcdWatcher knows about a key it has reported as existing.
+type keyState struct {
+	modRevision int64
+	lease       int64
 }
 
 // etcd file watcher implementation.
@@ -57,6 +92,7 @@ type EtcdWatcherCreator struct {
 
 // Create a new etcd watcher on the client "etcdClient". Listen for changes
 // of the key / prefix "path", and deliver notifications to "callback".
+// If "path" ends in a slash, all keys starting with it are watched.
 // Any errors will be returned on the given error channel "errchan.
 //
 // The callback function will receive the path of the actually modified key
@@ -66,58 +102,349 @@ type EtcdWatcherCreator struct {
 // to be notified of file modifications.
 func NewEtcdWatcher(etcdClient *etcd.Client, path string,
 	cb func(string, io.ReadCloser)) (*EtcdWatcher, error) {
-	var ret = &EtcdWatcher{
+	return NewEtcdWatcherContext(context.Background(), etcdClient, path, cb)
+}
+
+// Create a new etcd watcher like NewEtcdWatcher, which will stop watching
+// once "ctx" is done.
+func NewEtcdWatcherContext(ctx context.Context, etcdClient *etcd.Client,
+	path string, cb func(string, io.ReadCloser)) (*EtcdWatcher, error) {
+	return NewEtcdEventWatcher(ctx, etcdClient, path,
+		file.CallbackEventHandler(cb))
+}
+
+// Create a new etcd watcher on the client "etcdClient", listening for
+// changes of the key / prefix "path" and delivering an Event for each of
+// them to "handler" until "ctx" is done. If "path" ends in a slash, all
+// keys starting with it are watched, and their Event.Name is the part of
+// the key after "path".
+//
+// The current state is delivered first, as an OpCreate event for the key
+// or each key with the prefix, and changes are watched from the revision
+// of that snapshot on. The Sys field of the events holds an *EtcdFileInfo
+// describing the key after the change; its ModRevision is the revision of
+// the change. If revisions are compacted before their events have been
+// delivered, the state of the keys is fetched again, and the differences
+// to the state delivered so far are reported as events instead.
+func NewEtcdEventWatcher(ctx context.Context, etcdClient *etcd.Client,
+	path string, handler func(*file.Event)) (*EtcdWatcher, error) {
+	var ret *EtcdWatcher
+	var cancel context.CancelFunc
+	var resp *etcd.GetResponse
+	var kv *mvccpb.KeyValue
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = context.WithCancel(ctx)
+	ret = &EtcdWatcher{
+		ctx:        ctx,
 		etcdClient: etcdClient,
 		path:       path,
-		errchan:    make(chan error),
-		shutdown:   make(chan bool),
+		status:     file.NewWatchStatus(etcdURL(path)),
+		cancel:     cancel,
+		handler:    handler,
+		prefix:     strings.HasSuffix(path, "/"),
+		seen:       make(map[string]keyState),
+	}
+
+	// Treat the current state of the keys as the first change.
+	resp, err = ret.snapshot()
+	if err != nil {
+		cancel()
+		return nil, etcdError("watch", path, err)
 	}
+
+	for _, kv = range resp.Kvs {
+		if !isChunkKey(string(kv.Key)) {
+			ret.remember(kv)
+			handler(ret.newEvent(kv, file.OpCreate))
+		}
+	}
+
+	ret.rev = resp.Header.Revision + 1
 	go ret.watchForChanges()
 	return ret, nil
 }
 
+// Determine the etcd key or prefix to watch for the URL "u". Directories
+// can be watched by ending the path in a slash, or by adding recursive=1
+// to the query.
+func watchPath(u *url.URL) string {
+	var recursive string = u.Query().Get("recursive")
+
+	if (recursive == "1" || recursive == "true") &&
+		!strings.HasSuffix(u.Path, "/") {
+		return u.Path + "/"
+	}
+	return u.Path
+}
+
 // Create a new watcher object for watching for notifications on the
 // given URL.
 func (e *EtcdWatcherCreator) Watch(
 	file *url.URL, cb func(string, io.ReadCloser)) (
 	file.Watcher, error) {
-	return NewEtcdWatcher(e.etcdClient, file.Path, cb)
+	return NewEtcdWatcher(e.etcdClient, watchPath(file), cb)
+}
+
+// Create a new watcher object for watching for notifications on the
+// given URL until "ctx" is done.
+func (e *EtcdWatcherCreator) WatchContext(ctx context.Context,
+	file *url.URL, cb func(string, io.ReadCloser)) (
+	file.Watcher, error) {
+	return NewEtcdWatcherContext(ctx, e.etcdClient, watchPath(file), cb)
+}
+
+// Create a new watcher object delivering Events for changes of the given
+// URL until "ctx" is done.
+func (e *EtcdWatcherCreator) WatchEventsContext(ctx context.Context,
+	file *url.URL, handler func(*file.Event)) (file.Watcher, error) {
+	return NewEtcdEventWatcher(ctx, e.etcdClient, watchPath(file),
+		handler)
+}
+
+// Record the state of the key "kv", which is reported as existing.
+func (w *EtcdWatcher) remember(kv *mvccpb.KeyValue) {
+	w.seen[string(kv.Key)] = keyState{
+		modRevision: kv.ModRevision,
+		lease:       kv.Lease,
+	}
+}
+
+// Convert the etcd event "ev" into an Event, and record the state of the
+// key. Keys deleted by etcd because their lease expired are reported as
+// deleted like any others; the Lease in the EtcdFileInfo of deletions is
+// that of the key's last version the watcher has seen.
+func (w *EtcdWatcher) newEtcdEvent(ev *etcd.Event) *file.Event {
+	var kv mvccpb.KeyValue
+
+	if ev.Type == mvccpb.DELETE {
+		kv = *ev.Kv
+		kv.Lease = w.seen[string(kv.Key)].lease
+		delete(w.seen, string(kv.Key))
+		return w.newEvent(&kv, file.OpDelete)
+	}
+
+	w.remember(ev.Kv)
+	if ev.IsCreate() {
+		return w.newEvent(ev.Kv, file.OpCreate)
+	}
+	return w.newEvent(ev.Kv, file.OpModify)
+}
+
+// Create an Event reporting the operation "op" leaving the key as "kv".
+func (w *EtcdWatcher) newEvent(kv *mvccpb.KeyValue, op file.Op) *file.Event {
+	var key string = string(kv.Key)
+	var ev *file.Event
+	var info *EtcdFileInfo = &EtcdFileInfo{
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+		Lease:          kv.Lease,
+	}
+
+	if op&file.OpDelete != 0 {
+		ev = file.NewEvent(etcdURL(key), op, info, nil)
+	} else {
+		ev = file.NewEvent(etcdURL(key), op, info,
+			func() (io.ReadCloser, error) {
+				var data []byte
+				var err error
+
+				// Chunks are written in the same transaction
+				// as their manifest.
+				data, err = readValue(w.etcdClient.Ctx(),
+					w.etcdClient, kv, kv.ModRevision)
+				if err != nil {
+					return nil, err
+				}
+				return file.NewReadCloserFake(
+					bytes.NewReader(data)), nil
+			})
+	}
+	ev.Name = file.RelativeName(w.path, key)
+	return ev
+}
+
+// Fetch the current state of the watched key or keys, sorted by key.
+func (w *EtcdWatcher) snapshot() (*etcd.GetResponse, error) {
+	var opts []etcd.OpOption
+
+	if w.prefix {
+		opts = append(opts, etcd.WithPrefix(),
+			etcd.WithSort(etcd.SortByKey, etcd.SortAscend))
+	}
+	return w.etcdClient.Get(w.ctx, w.path, opts...)
+}
+
+// Fetch the current state of the watched keys after revisions which have
+// not been delivered yet were compacted, and deliver events for the
+// differences to the keys seen so far: OpCreate for new keys, OpModify for
+// keys which were changed and OpDelete for keys which are gone. Watching
+// then continues from the revision of the snapshot.
+func (w *EtcdWatcher) resynchronize() error {
+	var current map[string]bool = make(map[string]bool)
+	var resp *etcd.GetResponse
+	var kv *mvccpb.KeyValue
+	var state keyState
+	var gone []string
+	var key string
+	var ok bool
+	var err error
+
+	resp, err = w.snapshot()
+	if err != nil {
+		return etcdError("watch", w.path, err)
+	}
+
+	for _, kv = range resp.Kvs {
+		key = string(kv.Key)
+		if isChunkKey(key) {
+			continue
+		}
+
+		current[key] = true
+		state, ok = w.seen[key]
+		w.remember(kv)
+		if !ok {
+			w.handler(w.newEvent(kv, file.OpCreate))
+		} else if state.modRevision != kv.ModRevision {
+			w.handler(w.newEvent(kv, file.OpModify))
+		}
+	}
+
+	for key = range w.seen {
+		if !current[key] {
+			gone = append(gone, key)
+		}
+	}
+	sort.Strings(gone)
+
+	for _, key = range gone {
+		state = w.seen[key]
+		delete(w.seen, key)
+		w.handler(w.newEvent(&mvccpb.KeyValue{
+			Key:         []byte(key),
+			ModRevision: resp.Header.Revision,
+			Lease:       state.lease,
+		}, file.OpDelete))
+	}
+
+	w.rev = resp.Header.Revision + 1
+	w.resync = false
+	return nil
 }
 
 // Watch for changes on the EtcdWatcher and send out callbacks as they occur.
+// Whenever the watch fails, the error is reported on the error channel and
+// the watch is re-established after WatchRetryInterval, continuing after
+// the last revision delivered. If that revision has been compacted in the
+// meantime, the watcher resynchronizes with the current state first. This
+// runs until the watcher is shut down or the etcd client is closed, at
+// which point the watcher is marked as stopped.
 func (w *EtcdWatcher) watchForChanges() {
-	var ctx context.Context = context.Background()
+	defer w.status.Close()
+	defer w.cancel()
+
+	for {
+		w.watchOnce()
+
+		if w.etcdClient.Ctx().Err() != nil {
+			return
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(WatchRetryInterval):
+		}
+	}
+}
+
+// Run a single watch on etcd, delivering events until it fails or the
+// watcher is shut down.
+func (w *EtcdWatcher) watchOnce() {
+	var opts []etcd.OpOption = []etcd.OpOption{
+		etcd.WithRev(w.rev), etcd.WithProgressNotify()}
+	var ctx context.Context
+	var cancel context.CancelFunc
 	var wc etcd.WatchChan
 	var wr etcd.WatchResponse
-	var shutdown bool
+	var err error
 
-	wc = w.etcdClient.Watch(ctx, w.path)
+	if w.resync {
+		if err = w.resynchronize(); err != nil {
+			if w.ctx.Err() == nil {
+				w.status.Report(err)
+			}
+			return
+		}
+	}
 
-	select {
-	case wr = <-wc:
+	if w.prefix {
+		opts = append(opts, etcd.WithPrefix())
+	}
+
+	// Require a leader, so losing the connection to the quorum is
+	// reported as an error instead of silently stalling the watch.
+	ctx, cancel = context.WithCancel(etcd.WithRequireLeader(w.ctx))
+	defer cancel()
+
+	wc = w.etcdClient.Watch(ctx, w.path, opts...)
+	for wr = range wc {
 		var ev *etcd.Event
 
+		if err = wr.Err(); err != nil {
+			// The events up to CompactRevision are gone, so the
+			// changes have to be determined from a new snapshot.
+			if wr.CompactRevision != 0 {
+				w.resync = true
+			}
+			if w.ctx.Err() == nil {
+				w.status.Report(etcdError("watch", w.path, err))
+			}
+			return
+		}
+
 		for _, ev = range wr.Events {
-			w.cb(string(ev.Kv.Key),
-				file.NewReadCloserFake(bytes.NewReader(ev.Kv.Value)))
+			if !isChunkKey(string(ev.Kv.Key)) {
+				w.handler(w.newEtcdEvent(ev))
+			}
+			w.rev = ev.Kv.ModRevision + 1
 		}
 
-	case shutdown = <-w.shutdown:
-		if shutdown {
-			return
+		// Progress notifications guarantee all events up to their
+		// revision have been delivered.
+		if wr.IsProgressNotify() && wr.Header.Revision >= w.rev {
+			w.rev = wr.Header.Revision + 1
 		}
 	}
+
+	if w.ctx.Err() == nil {
+		w.status.Report(etcdError("watch", w.path, errWatchClosed))
+	}
 }
 
-// Shut down the listener after the next change.
-// There's no way to stop it immediately, though.
+// Stop listening for changes. This takes effect immediately; the watch on
+// the etcd server is cancelled as well.
 func (w *EtcdWatcher) Shutdown() error {
-	w.shutdown <- true
+	w.cancel()
 	return nil
 }
 
 // Retrieve the error channel associated with the watcher.
-// It will stream a list of all errors created while watching.
+// It will stream a list of all errors created while watching, such as
+// compactions and lost connections. Errors which occur while the channel
+// is full are dropped. The channel is closed once the watcher has stopped.
 func (w *EtcdWatcher) ErrChan() chan error {
-	return w.errchan
+	return w.status.ErrChan()
+}
+
+// Retrieve a channel which is closed once the watcher has stopped and
+// won't invoke its handler anymore.
+func (w *EtcdWatcher) Done() <-chan struct{} {
+	return w.status.Done()
 }
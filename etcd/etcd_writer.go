@@ -34,49 +34,172 @@ package etcd
 import (
 	"bytes"
 	"os"
+	"strconv"
 
+	"github.com/caoimhechaos/go-file"
 	etcd "github.com/coreos/etcd/clientv3"
 	"golang.org/x/net/context"
 )
 
 const (
-	MAX_FILE_LEN = (1 << 10)
+	// Default limit for the size of files written to etcd, 1 MiB. It
+	// can be changed when registering the client using WithMaxFileSize.
+	MAX_FILE_LEN = (1 << 20)
 )
 
 // etcd writer object. Unlike most other writers, all file contents are
 // only written when the writer is closed.
 type EtcdWriter struct {
+	ctx        context.Context
 	etcdClient *etcd.Client
 	path       string
 	buf        *bytes.Buffer
+	options    *EtcdOptions
+	conditions *file.WriteOptions
+	lease      *EtcdLease
 }
 
 // Create a new etcd writer for the file given at "path", on the etcd service
 // "etcdClient". Any contents in this writer will be written on Close().
 func NewEtcdWriter(etcdClient *etcd.Client, path string) *EtcdWriter {
+	return NewEtcdWriterContext(context.Background(), etcdClient, path)
+}
+
+// Create a new etcd writer for the file given at "path", on the etcd service
+// "etcdClient". The etcd request made by Close() will be aborted once "ctx"
+// is done.
+func NewEtcdWriterContext(ctx context.Context, etcdClient *etcd.Client,
+	path string) *EtcdWriter {
+	return NewEtcdWriterWithOptions(ctx, etcdClient, path, NewEtcdOptions())
+}
+
+// Create a new etcd writer like NewEtcdWriterContext, which limits the size
+// of the file and splits it into chunks as specified by "options". The file
+// is only written if the "conditions" hold when the writer is closed.
+func NewEtcdWriterWithOptions(ctx context.Context, etcdClient *etcd.Client,
+	path string, options *EtcdOptions,
+	conditions ...file.WriteOption) *EtcdWriter {
 	return &EtcdWriter{
+		ctx:        ctx,
 		etcdClient: etcdClient,
 		path:       path,
 		buf:        new(bytes.Buffer),
+		options:    options,
+		conditions: file.NewWriteOptions(conditions...),
 	}
 }
 
+// Determine whether the contents collected so far exceed the size limit.
+func (wr *EtcdWriter) tooLarge() bool {
+	return wr.options.MaxFileSize > 0 &&
+		int64(wr.buf.Len()) > wr.options.MaxFileSize
+}
+
 // Write the bytes given in "b" to the file on etcd. If the total size
-// of the file exceeds 1MB, an "invalid" error (os.ErrInvalid) will be
-// returned.
+// of the file exceeds the limit, MAX_FILE_LEN unless configured otherwise,
+// an error of the kind file.ErrTooLarge, which is also an "invalid" error
+// (os.ErrInvalid), will be returned.
 func (wr *EtcdWriter) Write(b []byte) (n int, err error) {
 	n, err = wr.buf.Write(b)
-	if err == nil && wr.buf.Len() > MAX_FILE_LEN {
-		err = os.ErrInvalid
+	if err == nil && wr.tooLarge() {
+		err = file.NewError("write", etcdURL(wr.path), file.ErrTooLarge,
+			os.ErrInvalid)
 	}
 	return
 }
 
-// Write the contents collected so far to the file in etcd.
+// Translate the conditions for writing the file into comparisons for the
+// transaction. ETags are the ModRevision of the key, so comparing against
+// an ETag which isn't a revision always fails.
+func (wr *EtcdWriter) compares() ([]etcd.Cmp, error) {
+	var cmps []etcd.Cmp
+	var rev int64
+	var err error
+
+	if wr.conditions.IfAbsent {
+		cmps = append(cmps, etcd.Compare(etcd.CreateRevision(wr.path), "=", 0))
+	}
+	if wr.conditions.IfPresent {
+		cmps = append(cmps, etcd.Compare(etcd.CreateRevision(wr.path), ">", 0))
+	}
+	if wr.conditions.IfMatch != "" {
+		rev, err = strconv.ParseInt(wr.conditions.IfMatch, 10, 64)
+		if err != nil {
+			return nil, file.NewError("write", etcdURL(wr.path),
+				file.ErrPreconditionFailed, err)
+		}
+		cmps = append(cmps, etcd.Compare(etcd.ModRevision(wr.path), "=", rev))
+	}
+	return cmps, nil
+}
+
+// Get the lease attached to the file if it was written with a TTL, so it can
+// be released. Returns nil before the writer has been closed successfully,
+// and for files written without a TTL.
+func (wr *EtcdWriter) Lease() *EtcdLease {
+	return wr.lease
+}
+
+// Write the contents collected so far to the file in etcd. If chunking is
+// enabled and the file is larger than the chunk size, it is stored in
+// chunks, each of which is written separately before the manifest
+// describing them replaces the previous version of the file. Either way,
+// readers will only ever see either the old or the new contents. Files
+// exceeding the size limit are not written at all, and neither are files
+// for which the conditions don't hold; for those, an error of the kind
+// file.ErrPreconditionFailed is returned.
+//
+// If the writer was given a TTL, the file is attached to a new lease, which
+// is kept alive until it is released through Lease().
 func (wr *EtcdWriter) Close() error {
-	var ctx context.Context = context.Background()
+	var data []byte = wr.buf.Bytes()
+	var chunkSize int = wr.options.ChunkSize
+	var magic bool = bytes.HasPrefix(data, []byte(manifestMagic))
+	var grant *etcd.LeaseGrantResponse
+	var putOpts []etcd.OpOption
+	var resp *etcd.TxnResponse
+	var cmps []etcd.Cmp
 	var err error
 
-	_, err = wr.etcdClient.Put(ctx, wr.path, wr.buf.String())
-	return err
+	if wr.tooLarge() {
+		return file.NewError("write", etcdURL(wr.path), file.ErrTooLarge,
+			os.ErrInvalid)
+	}
+	if cmps, err = wr.compares(); err != nil {
+		return err
+	}
+
+	if wr.conditions.TTL > 0 {
+		grant, err = wr.etcdClient.Grant(wr.ctx,
+			ttlSeconds(wr.conditions.TTL))
+		if err != nil {
+			return etcdError("write", wr.path, err)
+		}
+		putOpts = append(putOpts, etcd.WithLease(grant.ID))
+	}
+
+	// Files looking like a manifest are always chunked; see manifestMagic.
+	if magic && chunkSize <= 0 {
+		chunkSize = len(data)
+	}
+	if chunkSize > 0 && (len(data) > chunkSize || magic) {
+		err = putChunked(wr.ctx, wr.etcdClient, wr.path, data, chunkSize,
+			cmps, putOpts...)
+	} else {
+		resp, err = wr.etcdClient.Txn(wr.ctx).If(cmps...).Then(
+			plainPutOps(wr.path, data, putOpts...)...).Commit()
+		if err == nil && !resp.Succeeded {
+			err = file.NewError("write", etcdURL(wr.path),
+				file.ErrPreconditionFailed, nil)
+		}
+	}
+	if err == nil && grant != nil {
+		wr.lease, err = newEtcdLease(wr.etcdClient, wr.path, grant.ID)
+	}
+	if err != nil && grant != nil {
+		// Otherwise, the lease would only go away once it expires,
+		// taking the file with it if it has been written.
+		wr.etcdClient.Revoke(wr.ctx, grant.ID)
+	}
+	return etcdError("write", wr.path, err)
 }
@@ -0,0 +1,274 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Operations which can be reported by an Event. An event may have more than
+// one bit set if the backend coalesces changes.
+type Op uint32
+
+const (
+	// The file was created. The current state of files is also reported
+	// as OpCreate events when a watch is started.
+	OpCreate Op = 1 << iota
+
+	// The contents of the file were modified.
+	OpModify
+
+	// The file was removed. Its contents can no longer be read.
+	OpDelete
+
+	// The file was renamed to a different name, so it no longer exists
+	// under its old one. If the new name is being watched as well, it
+	// will be reported as a separate OpCreate event.
+	OpRename
+)
+
+// Get a human readable representation of "op", such as "CREATE|MODIFY".
+func (op Op) String() string {
+	var names []string
+
+	if op&OpCreate != 0 {
+		names = append(names, "CREATE")
+	}
+	if op&OpModify != 0 {
+		names = append(names, "MODIFY")
+	}
+	if op&OpDelete != 0 {
+		names = append(names, "DELETE")
+	}
+	if op&OpRename != 0 {
+		names = append(names, "RENAME")
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, "|")
+}
+
+// A change to a watched file.
+type Event struct {
+	// URL of the file which was changed.
+	URL *url.URL
+
+	// Path of the changed file relative to the watched URL, without a
+	// leading slash. Empty if the watched file itself was changed.
+	Name string
+
+	// What happened to the file.
+	Op Op
+
+	// Backend specific metadata about the change, such as the revision
+	// of an etcd key. May be nil.
+	Sys interface{}
+
+	// If set, the event doesn't report a change but an error which
+	// occurred while watching "URL". Only used by WatchChan.
+	Err error
+
+	// Function for opening the contents of the file, or nil if there
+	// are none.
+	open func() (io.ReadCloser, error)
+}
+
+// Create a new event reporting the operation "op" on the file "u". "open"
+// will be invoked to get the contents of the file when they are requested
+// through Open(); it should be nil for events which leave no contents
+// behind, such as OpDelete. It may be invoked more than once, e.g. once for
+// each subscriber of a WatchHub, and must return a new reader each time.
+func NewEvent(u *url.URL, op Op, sys interface{},
+	open func() (io.ReadCloser, error)) *Event {
+	return &Event{
+		URL:  u,
+		Op:   op,
+		Sys:  sys,
+		open: open,
+	}
+}
+
+// Get a reader for the contents of the file after the change. Content is
+// only fetched when this is called, so handlers which are only interested
+// in the fact that a file changed don't have to pay for reading it. If
+// there is no content, e.g. because the file was deleted, an error of the
+// kind ErrNotExist is returned. For error events, Err is returned.
+func (e *Event) Open() (io.ReadCloser, error) {
+	if e.Err != nil {
+		return nil, e.Err
+	}
+	if e.open == nil {
+		return nil, NewError("open", e.URL, ErrNotExist, nil)
+	}
+	return e.open()
+}
+
+// Objects which can deliver structured Events about changes to files.
+// Both FileSystem and WatcherCreator implementations may implement this;
+// those which don't will have their callbacks converted into OpModify events.
+// The watcher created will be shut down once the context is done.
+type EventWatcherCreator interface {
+	WatchEventsContext(context.Context, *url.URL, func(*Event)) (
+		Watcher, error)
+}
+
+// Convert a callback of the type accepted by Watch into a handler for
+// Events. The callback receives the path of the changed file and its
+// contents; deleted files, and files which can't be read anymore, are
+// reported with empty contents.
+func CallbackEventHandler(cb func(string, io.ReadCloser)) func(*Event) {
+	return func(ev *Event) {
+		var rc io.ReadCloser
+		var err error = ErrNotExist
+
+		if ev.Op&(OpDelete|OpRename) == 0 {
+			rc, err = ev.Open()
+		}
+		if err != nil {
+			rc = NewReadCloserFake(bytes.NewReader(nil))
+		}
+		cb(ev.URL.Path, rc)
+	}
+}
+
+// Convert Events into callbacks of the type accepted by Watch for watchers
+// which don't produce Events themselves. All changes are reported as OpModify
+// events for the path passed to the callback, on the scheme and host of "u".
+// The contents are read right away, as the reader passed to the callback
+// may not be valid after it returns, and can then be opened any number of
+// times.
+func eventCallback(u *url.URL, handler func(*Event)) func(
+	string, io.ReadCloser) {
+	return func(name string, rc io.ReadCloser) {
+		var eu url.URL = *u
+		var data []byte
+		var ev *Event
+		var err error
+
+		eu.Path = name
+		if rc != nil {
+			data, err = ioutil.ReadAll(rc)
+			rc.Close()
+		}
+
+		ev = NewEvent(&eu, OpModify, nil, func() (io.ReadCloser, error) {
+			if rc == nil {
+				return nil, NewError("open", &eu, ErrNotExist, nil)
+			}
+			if err != nil {
+				return nil, WrapError("open", &eu, err)
+			}
+			return NewReadCloserFake(bytes.NewReader(data)), nil
+		})
+		ev.Name = RelativeName(u.Path, name)
+		handler(ev)
+	}
+}
+
+// Get the path "name" relative to the watched path "base", as used for
+// Event.Name. If "name" is not below "base", it is returned unchanged.
+func RelativeName(base, name string) string {
+	var prefix string = strings.TrimSuffix(base, "/") + "/"
+
+	if name == base || name+"/" == base {
+		return ""
+	}
+	if strings.HasPrefix(name, prefix) {
+		return name[len(prefix):]
+	}
+	return name
+}
+
+// Channel of Events fed by a watcher, as returned by WatchChan. Events are
+// only sent while the context is not done, so the channel can be closed
+// safely once all pending sends have given up.
+type eventChan struct {
+	ctx     context.Context
+	ch      chan *Event
+	mtx     sync.Mutex
+	closed  bool
+	senders sync.WaitGroup
+}
+
+// Send "ev" on the channel, unless it has been closed. Blocks until the
+// event has been received or the context is done.
+func (c *eventChan) send(ev *Event) {
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return
+	}
+	c.senders.Add(1)
+	c.mtx.Unlock()
+
+	defer c.senders.Done()
+
+	select {
+	case c.ch <- ev:
+	case <-c.ctx.Done():
+	}
+}
+
+// Forward all errors from "errchan" to the channel as error events for
+// "u" until the context is done.
+func (c *eventChan) forwardErrors(u *url.URL, errchan chan error) {
+	for {
+		select {
+		case err, ok := <-errchan:
+			if !ok {
+				return
+			}
+			c.send(&Event{URL: u, Err: err})
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// Close the channel once all pending sends have returned. Must only be
+// called once no send can block anymore, e.g. after the context is done.
+func (c *eventChan) close() {
+	c.mtx.Lock()
+	c.closed = true
+	c.mtx.Unlock()
+
+	c.senders.Wait()
+	close(c.ch)
+}
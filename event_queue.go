@@ -0,0 +1,256 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// An Event waiting for delivery by an EventQueue.
+type queuedEvent struct {
+	ev  *Event
+	key string
+	due time.Time
+}
+
+// Queue delivering Events to a handler from a separate goroutine, one at a
+// time and in the order they were pushed, so the handler never runs
+// concurrently with itself and watchers are never blocked by slow handlers.
+//
+// If coalescing is enabled, an event for a file which still has an event
+// waiting for delivery is merged into the waiting one. With a debounce
+// period, events are additionally held back until there were no further
+// events for the same file for that long. Events are then delivered in
+// the order in which they became due.
+type EventQueue struct {
+	ctx      context.Context
+	handler  func(*Event)
+	coalesce bool
+	debounce time.Duration
+
+	mtx     sync.Mutex
+	pending []*queuedEvent
+	byKey   map[string]*queuedEvent
+	wakeup  chan bool
+
+	stop     chan bool
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Create a new EventQueue delivering events to "handler" until "ctx" is
+// done or the queue is closed. The Coalesce and Debounce options from
+// "opts" determine whether events are merged or held back.
+func NewEventQueue(ctx context.Context, handler func(*Event),
+	opts ...WatchOption) *EventQueue {
+	var options *WatchOptions = NewWatchOptions(opts...)
+	var ret = &EventQueue{
+		ctx:      ctx,
+		handler:  handler,
+		coalesce: options.Coalesce || options.Debounce > 0,
+		debounce: options.Debounce,
+		byKey:    make(map[string]*queuedEvent),
+		wakeup:   make(chan bool, 1),
+		stop:     make(chan bool),
+		done:     make(chan struct{}),
+	}
+
+	go ret.deliverEvents()
+	return ret
+}
+
+// Merge the event "ev" into the earlier event "old" for the same file. The
+// result describes the latest state of the file. Its operation combines
+// both, unless the file is gone in the end, in which case only that counts.
+func mergeEvents(old, ev *Event) *Event {
+	var ret Event = *ev
+
+	if ev.Op&(OpDelete|OpRename) == 0 {
+		ret.Op |= old.Op &^ (OpDelete | OpRename)
+	}
+	return &ret
+}
+
+// Queue "ev" for delivery.
+func (q *EventQueue) Push(ev *Event) {
+	var due time.Time = time.Now().Add(q.debounce)
+	var key string = ev.URL.String()
+	var qe *queuedEvent
+	var ok bool
+
+	q.mtx.Lock()
+	if qe, ok = q.byKey[key]; ok && q.coalesce {
+		qe.ev = mergeEvents(qe.ev, ev)
+		qe.due = due
+	} else {
+		qe = &queuedEvent{ev: ev, key: key, due: due}
+		q.pending = append(q.pending, qe)
+		if q.coalesce {
+			q.byKey[key] = qe
+		}
+	}
+	q.mtx.Unlock()
+
+	select {
+	case q.wakeup <- true:
+	default:
+	}
+}
+
+// Remove the first event which is due from the queue. If none is due, the
+// time until the next one will be is returned, or a negative duration if
+// the queue is empty.
+func (q *EventQueue) next() (*Event, time.Duration) {
+	var now time.Time = time.Now()
+	var qe *queuedEvent
+	var first int = -1
+	var i int
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if len(q.pending) == 0 {
+		return nil, -1
+	}
+
+	for i = range q.pending {
+		if first < 0 || q.pending[i].due.Before(q.pending[first].due) {
+			first = i
+		}
+	}
+
+	if q.pending[first].due.After(now) {
+		return nil, q.pending[first].due.Sub(now)
+	}
+
+	qe = q.pending[first]
+	q.pending = append(q.pending[:first], q.pending[first+1:]...)
+	if q.byKey[qe.key] == qe {
+		delete(q.byKey, qe.key)
+	}
+	return qe.ev, 0
+}
+
+// Deliver events to the handler as they become due, until the queue is
+// closed or the context is done.
+func (q *EventQueue) deliverEvents() {
+	defer close(q.done)
+
+	for {
+		var timer <-chan time.Time
+		var ev *Event
+		var wait time.Duration
+
+		for ev, wait = q.next(); ev != nil; ev, wait = q.next() {
+			select {
+			case <-q.stop:
+				return
+			case <-q.ctx.Done():
+				return
+			default:
+			}
+
+			q.handler(ev)
+		}
+
+		if wait > 0 {
+			timer = time.After(wait)
+		}
+
+		select {
+		case <-q.wakeup:
+		case <-timer:
+		case <-q.stop:
+			return
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop delivering events. Events which have not been delivered yet are
+// discarded. It is safe to call this more than once.
+func (q *EventQueue) Close() {
+	q.stopOnce.Do(func() {
+		close(q.stop)
+	})
+}
+
+// Get a channel which is closed once the queue has stopped delivering
+// events, i.e. after it has been closed and the handler has returned.
+func (q *EventQueue) Done() <-chan struct{} {
+	return q.done
+}
+
+// Wrapper around watchers whose events are delivered through an EventQueue,
+// closing the queue when the watcher is shut down.
+type queuedWatcher struct {
+	DoneWatcher
+	queue *EventQueue
+	done  chan struct{}
+}
+
+// Wrap "watcher", whose events are delivered through "queue".
+func newQueuedWatcher(watcher DoneWatcher, queue *EventQueue) *queuedWatcher {
+	var ret = &queuedWatcher{
+		DoneWatcher: watcher,
+		queue:       queue,
+		done:        make(chan struct{}),
+	}
+
+	go ret.waitForDone()
+	return ret
+}
+
+// Close the queue once the watcher has stopped, and mark the wrapper as
+// done once the queue has stopped as well.
+func (w *queuedWatcher) waitForDone() {
+	<-w.DoneWatcher.Done()
+	w.queue.Close()
+	<-w.queue.Done()
+	close(w.done)
+}
+
+// Stop the watcher, and discard all events not yet delivered.
+func (w *queuedWatcher) Shutdown() error {
+	w.queue.Close()
+	return w.DoneWatcher.Shutdown()
+}
+
+// Retrieve a channel which is closed once both the watcher and the queue
+// have stopped.
+func (w *queuedWatcher) Done() <-chan struct{} {
+	return w.done
+}
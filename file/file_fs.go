@@ -32,14 +32,20 @@
 package file
 
 import (
+	"fmt"
 	"io"
 	"net/url"
 	"os"
 	"path"
+	"syscall"
 
 	"github.com/caoimhechaos/go-file"
+	"golang.org/x/net/context"
 )
 
+// Number of directory entries to read at a time when listing directories.
+const listBatchSize = 256
+
 // Automatically sign us up for file:// URLs.
 func init() {
 	file.RegisterFileSystem("file", &FileFileSystemIntegration{})
@@ -47,46 +53,190 @@ func init() {
 
 // Go File system integration for the local file system.
 type FileFileSystemIntegration struct {
+	// Replace all files written atomically, as if file.WithAtomic had
+	// been given.
+	Atomic bool
 }
 
 // Open the file pointed to by "u" for reading.
 func (f *FileFileSystemIntegration) Open(u *url.URL) (io.ReadCloser, error) {
-	return os.Open(u.Path)
+	return f.OpenContext(context.Background(), u)
+}
+
+// Open the file pointed to by "u" for reading, unless "ctx" is already
+// done. Local file reads cannot be interrupted, so the context is only
+// consulted before opening the file.
+func (f *FileFileSystemIntegration) OpenContext(
+	ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	var fp *os.File
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fp, err = os.Open(u.Path)
+	if err != nil {
+		return nil, file.WrapError("open", u, err)
+	}
+	return fp, nil
 }
 
 // Open the file pointed to by "u" for writing.
 func (f *FileFileSystemIntegration) OpenForWrite(u *url.URL) (
 	io.WriteCloser, error) {
+	return f.OpenForWriteContext(context.Background(), u)
+}
+
+// Open the file pointed to by "u" for writing, unless "ctx" is already done.
+func (f *FileFileSystemIntegration) OpenForWriteContext(
+	ctx context.Context, u *url.URL) (io.WriteCloser, error) {
+	return f.OpenForWriteOptionsContext(ctx, u, file.NewWriteOptions())
+}
+
+// Open the file pointed to by "u" for writing if the conditions in "opts"
+// hold, unless "ctx" is already done. Files which must be absent are
+// created using O_EXCL. For files which must match an ETag, the inode,
+// modification time and size of the opened file are compared to it before
+// the file is truncated. All conditions are checked when opening the file.
+//
+// Files written atomically are written using an AtomicFileWriter instead,
+// which also checks the conditions again when it is closed.
+func (f *FileFileSystemIntegration) OpenForWriteOptionsContext(
+	ctx context.Context, u *url.URL, opts *file.WriteOptions) (
+	io.WriteCloser, error) {
+	var flags int = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var fp *os.File
+	var fi os.FileInfo
 	var err error
-	err = os.MkdirAll(path.Dir(u.Path), 0755)
-	if err != nil {
+
+	if err = ctx.Err(); err != nil {
 		return nil, err
 	}
-	return os.OpenFile(u.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if opts.TTL > 0 {
+		return nil, file.NewError("write", u, file.ErrUnsupported, nil)
+	}
+
+	if opts.Atomic || f.Atomic {
+		return NewAtomicFileWriter(ctx, u, opts)
+	}
+
+	if opts.IfAbsent {
+		flags |= os.O_EXCL
+	}
+	if opts.IfPresent || opts.IfMatch != "" {
+		flags &^= os.O_CREATE
+	} else {
+		err = os.MkdirAll(path.Dir(u.Path), 0755)
+		if err != nil {
+			return nil, file.WrapError("write", u, err)
+		}
+	}
+	if opts.IfMatch != "" {
+		// Only truncate the file once it's known to be the right one.
+		flags &^= os.O_TRUNC
+	}
+
+	fp, err = os.OpenFile(u.Path, flags, 0644)
+	if opts.IfAbsent && os.IsExist(err) {
+		return nil, file.NewError("write", u, file.ErrPreconditionFailed, err)
+	}
+	if (opts.IfPresent || opts.IfMatch != "") && os.IsNotExist(err) {
+		return nil, file.NewError("write", u, file.ErrPreconditionFailed, err)
+	}
+	if err != nil {
+		return nil, file.WrapError("write", u, err)
+	}
+
+	if opts.IfMatch != "" {
+		if fi, err = fp.Stat(); err != nil {
+			fp.Close()
+			return nil, file.WrapError("write", u, err)
+		}
+		if fileETag(fi) != opts.IfMatch {
+			fp.Close()
+			return nil, file.NewError("write", u, file.ErrPreconditionFailed,
+				nil)
+		}
+		if err = fp.Truncate(0); err != nil {
+			fp.Close()
+			return nil, file.WrapError("write", u, err)
+		}
+	}
+	return fp, nil
 }
 
 // Open the file pointed to by "u" for appending.
 func (f *FileFileSystemIntegration) OpenForAppend(u *url.URL) (
 	io.WriteCloser, error) {
+	return f.OpenForAppendContext(context.Background(), u)
+}
+
+// Open the file pointed to by "u" for appending, unless "ctx" is already
+// done.
+func (f *FileFileSystemIntegration) OpenForAppendContext(
+	ctx context.Context, u *url.URL) (io.WriteCloser, error) {
+	var fp *os.File
 	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
 	err = os.MkdirAll(path.Dir(u.Path), 0755)
 	if err != nil {
-		return nil, err
+		return nil, file.WrapError("append", u, err)
 	}
-	return os.OpenFile(u.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+	fp, err = os.OpenFile(u.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, file.WrapError("append", u, err)
+	}
+	return fp, nil
 }
 
 // Return a list of all files in the directory given in "u".
 func (f *FileFileSystemIntegration) List(u *url.URL) ([]string, error) {
+	return f.ListContext(context.Background(), u)
+}
+
+// Return a list of all files in the directory given in "u". The directory
+// is read in batches, and listing is aborted once "ctx" is done.
+func (f *FileFileSystemIntegration) ListContext(
+	ctx context.Context, u *url.URL) ([]string, error) {
 	var dir *os.File
+	var ret []string
 	var err error
 
+	if err = ctx.Err(); err != nil {
+		return []string{}, err
+	}
+
 	dir, err = os.Open(u.Path)
 	if err != nil {
-		return []string{}, err
+		return []string{}, file.WrapError("list", u, err)
 	}
+	defer dir.Close()
 
-	return dir.Readdirnames(-1)
+	for {
+		var names []string
+		var name string
+
+		if err = ctx.Err(); err != nil {
+			return []string{}, err
+		}
+
+		names, err = dir.Readdirnames(listBatchSize)
+		for _, name = range names {
+			if !isTempFile(name) {
+				ret = append(ret, name)
+			}
+		}
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return ret, file.WrapError("list", u, err)
+		}
+	}
 }
 
 // Create a new watcher object for watching for notifications on the
@@ -94,10 +244,99 @@ func (f *FileFileSystemIntegration) List(u *url.URL) ([]string, error) {
 func (f *FileFileSystemIntegration) Watch(
 	fileid *url.URL, cb func(string, io.ReadCloser)) (
 	file.Watcher, error) {
-	return NewFileWatcher(fileid.Path, cb)
+	return watchURL(context.Background(), fileid,
+		file.CallbackEventHandler(cb))
+}
+
+// Create a new watcher object for watching for notifications on the
+// given URL until "ctx" is done.
+func (f *FileFileSystemIntegration) WatchContext(ctx context.Context,
+	fileid *url.URL, cb func(string, io.ReadCloser)) (
+	file.Watcher, error) {
+	return watchURL(ctx, fileid, file.CallbackEventHandler(cb))
+}
+
+// Create a new watcher object delivering Events for changes of the given
+// URL until "ctx" is done.
+func (f *FileFileSystemIntegration) WatchEventsContext(ctx context.Context,
+	fileid *url.URL, handler func(*file.Event)) (file.Watcher, error) {
+	return watchURL(ctx, fileid, handler)
 }
 
 // Remove the specified file from the file system.
 func (f *FileFileSystemIntegration) Remove(u *url.URL) error {
-	return os.Remove(u.Path)
+	return f.RemoveContext(context.Background(), u)
+}
+
+// Remove the specified file from the file system, unless "ctx" is
+// already done.
+func (f *FileFileSystemIntegration) RemoveContext(
+	ctx context.Context, u *url.URL) error {
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return file.WrapError("remove", u, os.Remove(u.Path))
+}
+
+// Retrieve metadata about the file given as "u", unless "ctx" is already
+// done.
+func (f *FileFileSystemIntegration) StatContext(
+	ctx context.Context, u *url.URL) (os.FileInfo, error) {
+	var fi os.FileInfo
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	fi, err = os.Stat(u.Path)
+	if err != nil {
+		return nil, file.WrapError("stat", u, err)
+	}
+	return &fileInfo{fi}, nil
+}
+
+// Metadata about a local file, which can also describe its version.
+type fileInfo struct {
+	os.FileInfo
+}
+
+// Describe the version of the file by its inode, modification time and
+// size.
+func (fi *fileInfo) ETag() string {
+	return fileETag(fi.FileInfo)
+}
+
+// Describe the version of the file "fi" was retrieved for by its inode,
+// modification time and size.
+func fileETag(fi os.FileInfo) string {
+	return fmt.Sprintf("%x-%x-%x", inode(fi), fi.ModTime().UnixNano(),
+		fi.Size())
+}
+
+// Rename the file "src" to "dst" using os.Rename. If "dst" is on a
+// different device, FS_OperationNotImplementedError is returned so the
+// file will be copied instead.
+func (f *FileFileSystemIntegration) RenameContext(
+	ctx context.Context, src, dst *url.URL) error {
+	var linkErr *os.LinkError
+	var ok bool
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(path.Dir(dst.Path), 0755)
+	if err != nil {
+		return file.WrapError("rename", dst, err)
+	}
+
+	err = os.Rename(src.Path, dst.Path)
+	if linkErr, ok = err.(*os.LinkError); ok && linkErr.Err == syscall.EXDEV {
+		return file.FS_OperationNotImplementedError
+	}
+	return file.WrapError("rename", src, err)
 }
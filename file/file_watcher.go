@@ -35,8 +35,12 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/caoimhechaos/go-file"
+	"golang.org/x/net/context"
 	"gopkg.in/fsnotify.v1"
 )
 
@@ -49,33 +53,76 @@ type FileWatcherCreator struct {
 func (f *FileWatcherCreator) Watch(
 	fileid *url.URL, cb func(string, io.ReadCloser)) (
 	file.Watcher, error) {
-	return NewFileWatcher(fileid.Path, cb)
+	return watchURL(context.Background(), fileid,
+		file.CallbackEventHandler(cb))
 }
 
-// Object for watching an individual file for changes.
-type FileWatcher struct {
-	cb       func(string, io.ReadCloser)
-	watcher  *fsnotify.Watcher
-	path     string
-	shutdown bool
+// Create a new watcher object for watching for notifications on the
+// given URL until "ctx" is done.
+func (f *FileWatcherCreator) WatchContext(ctx context.Context,
+	fileid *url.URL, cb func(string, io.ReadCloser)) (
+	file.Watcher, error) {
+	return watchURL(ctx, fileid, file.CallbackEventHandler(cb))
 }
 
-// Resolve an absolute and a relative path to a new absolute path.
-func resolveRelative(orig, relative string) (path string, err error) {
-	var origurl, newurl *url.URL
+// Create a new watcher object delivering Events for changes of the given
+// URL until "ctx" is done.
+func (f *FileWatcherCreator) WatchEventsContext(ctx context.Context,
+	fileid *url.URL, handler func(*file.Event)) (file.Watcher, error) {
+	return watchURL(ctx, fileid, handler)
+}
 
-	origurl, err = url.Parse(orig)
-	if err != nil {
-		return
-	}
+// Create a new watcher delivering Events for changes of "u" to "handler".
+// Directories are watched recursively if the query of "u" contains
+// recursive=1.
+func watchURL(ctx context.Context, u *url.URL, handler func(*file.Event)) (
+	file.Watcher, error) {
+	var recursive string = u.Query().Get("recursive")
+	var ret *FileWatcher
+	var err error
 
-	newurl, err = origurl.Parse(relative)
+	if recursive == "1" || recursive == "true" {
+		ret, err = NewRecursiveFileEventWatcher(ctx, u.Path, handler)
+	} else {
+		ret, err = NewFileEventWatcher(ctx, u.Path, handler)
+	}
 	if err != nil {
-		return
+		return nil, err
 	}
+	return ret, nil
+}
 
-	path = newurl.String()
-	return
+// Object for watching an individual file for changes.
+type FileWatcher struct {
+	ctx     context.Context
+	handler func(*file.Event)
+	watcher *fsnotify.Watcher
+	path    string
+	status  *file.WatchStatus
+
+	stopOnce sync.Once
+
+	// Queue delivering events to the handler in order, so the fsnotify
+	// events can be processed while the handler is busy. Set once the
+	// initial state has been reported.
+	queue *file.EventQueue
+
+	// Whether subdirectories are watched as well. If so, "dirs" holds
+	// all directories added to "watcher". It is only used by the
+	// goroutine processing events once the watcher has been started.
+	recursive bool
+	dirs      map[string]bool
+
+	// When watching a single file, its parent directory is watched
+	// instead, so replacing the file by renaming another one over it or
+	// by swapping a symlink is noticed. "resolved" is the path the file
+	// currently resolves to, "targetDir" is its directory if it is
+	// watched in addition to the parent, and "last" is the state of the
+	// file when it was last reported, or nil if it doesn't exist.
+	single    bool
+	resolved  string
+	targetDir string
+	last      os.FileInfo
 }
 
 // Automatically sign us up for file:// URLs.
@@ -86,129 +133,395 @@ func init() {
 // Create a new FileWatcher watching the file at "path".
 func NewFileWatcher(path string, cb func(string, io.ReadCloser)) (
 	*FileWatcher, error) {
+	return NewFileWatcherContext(context.Background(), path, cb)
+}
+
+// Create a new FileWatcher watching the file at "path". The watcher will
+// be shut down once "ctx" is done.
+func NewFileWatcherContext(ctx context.Context, path string,
+	cb func(string, io.ReadCloser)) (*FileWatcher, error) {
+	return NewFileEventWatcher(ctx, path, file.CallbackEventHandler(cb))
+}
+
+// Create an Event for the operation "op" on the file at "path", which is
+// being watched as part of "base". Unless the file is gone, its contents
+// will be opened when requested.
+func newFileEvent(base, path string, op file.Op, sys interface{}) *file.Event {
+	var u *url.URL = &url.URL{Scheme: "file", Path: path}
+	var ev *file.Event
+
+	if op&(file.OpDelete|file.OpRename) != 0 {
+		ev = file.NewEvent(u, op, sys, nil)
+		ev.Name = file.RelativeName(base, path)
+		return ev
+	}
+
+	ev = file.NewEvent(u, op, sys, func() (io.ReadCloser, error) {
+		var f *os.File
+		var err error
+
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, file.WrapError("open", u, err)
+		}
+		return f, nil
+	})
+	ev.Name = file.RelativeName(base, path)
+	return ev
+}
+
+// Create a new FileWatcher watching the file or directory at "path" and
+// delivering an Event for every change to "handler". The current state is
+// reported first, as an OpCreate event for the file or each file in the
+// directory. The Sys field of subsequent events holds the fsnotify.Event
+// which triggered them. Events are delivered one at a time, in the order
+// they occurred.
+//
+// Files are watched by their path rather than by inode: replacing a file
+// by renaming another one over it, or by swapping a symlink leading to it,
+// is reported as a single OpModify event, as is any other change of the
+// file's identity, size or modification time. Removing the file, or
+// renaming it away, is reported as OpDelete, and creating it again as
+// OpCreate. The watcher will be shut down once "ctx" is done.
+func NewFileEventWatcher(ctx context.Context, path string,
+	handler func(*file.Event)) (*FileWatcher, error) {
+	return newFileWatcher(ctx, path, handler, false)
+}
+
+// Create a new FileWatcher like NewFileEventWatcher, which will also watch
+// all subdirectories of "path", including those created later on. Events
+// are reported with the full path of the changed file in the URL, and the
+// path relative to "path" in the Name. The current state is reported as an
+// OpCreate event for each file in the whole tree.
+func NewRecursiveFileEventWatcher(ctx context.Context, path string,
+	handler func(*file.Event)) (*FileWatcher, error) {
+	return newFileWatcher(ctx, path, handler, true)
+}
+
+// Create a new FileWatcher, watching subdirectories if "recursive" is set.
+func newFileWatcher(ctx context.Context, path string,
+	handler func(*file.Event), recursive bool) (*FileWatcher, error) {
 	var fi os.FileInfo
 	var ret *FileWatcher
 	var watcher *fsnotify.Watcher
 	var err error
 
-	watcher, err = fsnotify.NewWatcher()
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Paths of files in the directory are built from this one, so e.g. a
+	// trailing slash must not end up in them.
+	path = filepath.Clean(path)
+
+	fi, err = os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
 
-	err = watcher.Add(path)
+	watcher, err = fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
 
 	ret = &FileWatcher{
-		cb:      cb,
-		watcher: watcher,
-		path:    path,
+		ctx:       ctx,
+		handler:   handler,
+		watcher:   watcher,
+		path:      path,
+		status:    file.NewWatchStatus(&url.URL{Scheme: "file", Path: path}),
+		recursive: recursive,
+		dirs:      make(map[string]bool),
+		single:    !fi.IsDir(),
 	}
 
-	// Treat the current state of the file as the first change.
-	fi, err = os.Stat(path)
+	if ret.single {
+		err = watcher.Add(filepath.Dir(path))
+	} else {
+		err = watcher.Add(path)
+	}
 	if err != nil {
+		watcher.Close()
 		return nil, err
 	}
 
-	if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
-		var subpath string
-
-		subpath, err = os.Readlink(path)
-		if err != nil {
-			return nil, err
-		}
-
-		path, err = resolveRelative(path, subpath)
+	// Treat the current state of the file as the first change.
+	if ret.single {
+		ret.last = fi
+		ret.follow()
+		handler(newFileEvent(path, path, file.OpCreate, nil))
+	} else if recursive {
+		err = ret.addTree(path)
 		if err != nil {
+			watcher.Close()
 			return nil, err
 		}
-	}
-
-	if fi.IsDir() {
+	} else {
 		var names []string
 		var name string
 		var f *os.File
 
 		f, err = os.Open(path)
 		if err != nil {
+			watcher.Close()
 			return nil, err
 		}
 
 		names, err = f.Readdirnames(-1)
+		f.Close()
 		if err != nil {
+			watcher.Close()
 			return nil, err
 		}
 
 		for _, name = range names {
-			var combined string
-			var reader *os.File
-
-			combined, err = resolveRelative(path+"/", name)
-			if err != nil {
-				return nil, err
+			if !isTempFile(name) {
+				handler(newFileEvent(path, filepath.Join(path, name),
+					file.OpCreate, nil))
 			}
-
-			reader, err = os.Open(combined)
-			cb(combined, reader)
 		}
+	}
 
-		f.Close()
+	ret.queue = file.NewEventQueue(ctx, handler)
+	go ret.watchForChanges()
+
+	return ret, nil
+}
+
+// Report "ev" to the handler. Before the watcher has been started, the
+// handler is invoked directly; afterwards, events are queued.
+func (f *FileWatcher) emit(ev *file.Event) {
+	if f.queue == nil {
+		f.handler(ev)
 	} else {
-		var reader *os.File
+		f.queue.Push(ev)
+	}
+}
 
-		reader, err = os.Open(path)
+// Resolve the symlinks leading to the watched file again, and make sure
+// the directory of the file it resolves to is watched as well, so changes
+// made to it in place are noticed.
+func (f *FileWatcher) follow() {
+	var dir string
+	var err error
+
+	f.resolved, err = filepath.EvalSymlinks(f.path)
+	if err != nil {
+		f.resolved = ""
+		return
+	}
+
+	dir = filepath.Dir(f.resolved)
+	if dir == f.targetDir {
+		return
+	}
+
+	if len(f.targetDir) > 0 {
+		f.watcher.Remove(f.targetDir)
+		f.targetDir = ""
+	}
+	if dir != filepath.Dir(f.path) && f.watcher.Add(dir) == nil {
+		f.targetDir = dir
+	}
+}
+
+// Determine whether the watched file has logically changed due to "event",
+// which may have occurred on any file in the directories being watched.
+// If so, report a single event for the file, reflecting the difference
+// between its current state and the one last reported.
+func (f *FileWatcher) checkFile(event fsnotify.Event) {
+	var oldResolved string = f.resolved
+	var fi os.FileInfo
+	var op file.Op
+	var err error
+
+	fi, err = os.Stat(f.path)
+	if err != nil {
+		fi = nil
+	}
+	f.follow()
+
+	switch {
+	case f.last == nil && fi == nil:
+		return
+	case f.last == nil:
+		op = file.OpCreate
+	case fi == nil:
+		op = file.OpDelete
+	case f.resolved != oldResolved || !os.SameFile(f.last, fi) ||
+		fi.Size() != f.last.Size() || !fi.ModTime().Equal(f.last.ModTime()):
+		op = file.OpModify
+	default:
+		return
+	}
+
+	f.last = fi
+	f.emit(newFileEvent(f.path, f.path, op, event))
+}
+
+// Add all subdirectories of "dir" to the watcher, and report all files in
+// them as created. Files and directories which disappear while walking the
+// tree are skipped.
+func (f *FileWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
 		if err != nil {
-			return nil, err
+			if p != dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
 		}
 
-		cb(path, reader)
+		if !fi.IsDir() {
+			if !isTempFile(p) {
+				f.emit(newFileEvent(f.path, p, file.OpCreate, nil))
+			}
+			return nil
+		}
+
+		if p != f.path {
+			if err = f.watcher.Add(p); err != nil {
+				if os.IsNotExist(err) {
+					return filepath.SkipDir
+				}
+				return err
+			}
+		}
+		f.dirs[p] = true
+		return nil
+	})
+}
+
+// Stop watching the directory "dir" and all of its subdirectories, after
+// it has been removed or renamed.
+func (f *FileWatcher) removeTree(dir string) {
+	var prefix string = dir + "/"
+	var p string
+
+	for p = range f.dirs {
+		if p == dir || strings.HasPrefix(p, prefix) {
+			// The watch is gone already if the directory was removed.
+			f.watcher.Remove(p)
+			delete(f.dirs, p)
+		}
 	}
+}
 
-	go ret.watchForChanges()
+// Keep the set of watched directories up to date with "event". Errors
+// adding new directories are reported on the error channel.
+func (f *FileWatcher) updateTree(event fsnotify.Event) {
+	var fi os.FileInfo
+	var err error
 
-	return ret, nil
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 &&
+		event.Name != f.path {
+		f.removeTree(event.Name)
+	}
+
+	if event.Op&fsnotify.Create == 0 {
+		return
+	}
+
+	fi, err = os.Stat(event.Name)
+	if err != nil || !fi.IsDir() {
+		return
+	}
+
+	// Files may have been created in the directory before the watch was
+	// added, so those are reported as created here.
+	err = f.addTree(event.Name)
+	if err != nil {
+		f.status.Report(err)
+	}
 }
 
 // Read events happening on the file being watched and forward them
-// to the relevant callback.
+// to the relevant callback. This runs until Shutdown() closes the
+// underlying fsnotify watcher, which closes its event channel. Once the
+// last event has been delivered, the watcher is marked as stopped.
 func (f *FileWatcher) watchForChanges() {
-	for !f.shutdown {
-		var event fsnotify.Event
+	var errors chan error = f.watcher.Errors
 
-		event = <-f.watcher.Events
+	defer func() {
+		f.queue.Close()
+		<-f.queue.Done()
+		f.status.Close()
+	}()
 
-		if event.Op&(fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 {
-			var fn *os.File
-			var err error
-
-			fn, err = os.Open(event.Name)
-			if err == nil {
-				go f.cb(event.Name, fn)
+	for {
+		var event fsnotify.Event
+		var op file.Op
+		var err error
+		var ok bool
+
+		select {
+		case event, ok = <-f.watcher.Events:
+			if !ok {
+				return
+			}
+		case err, ok = <-errors:
+			if !ok {
+				errors = nil
 			} else {
-				f.watcher.Errors <- err
+				f.status.Report(err)
 			}
+			continue
+		case <-f.ctx.Done():
+			f.Shutdown()
+			return
+		}
+
+		if f.single {
+			f.checkFile(event)
+			continue
+		}
+
+		if event.Op&fsnotify.Create != 0 {
+			op |= file.OpCreate
+		}
+		if event.Op&fsnotify.Write != 0 {
+			op |= file.OpModify
+		}
+		if event.Op&fsnotify.Remove != 0 {
+			op |= file.OpDelete
+		}
+		if event.Op&fsnotify.Rename != 0 {
+			op |= file.OpRename
+		}
+
+		// Changes of permissions are not reported, and neither are
+		// temporary files written by an AtomicFileWriter.
+		if op != 0 && !isTempFile(event.Name) {
+			f.emit(newFileEvent(f.path, event.Name, op, event))
+		}
+
+		if f.recursive {
+			f.updateTree(event)
 		}
 	}
 }
 
-// Stop listening for notifications on the file.
+// Stop listening for notifications on the file. This removes all watches,
+// including those on subdirectories. Events which have not been delivered
+// yet are discarded. It is safe to call this more than once.
 func (f *FileWatcher) Shutdown() error {
 	var err error
 
-	f.shutdown = true
-	err = f.watcher.Remove(f.path)
-	if err != nil {
-		return err
-	}
-
-	return f.watcher.Close()
+	f.stopOnce.Do(func() {
+		f.queue.Close()
+		err = f.watcher.Close()
+	})
+	return err
 }
 
 // Retrieve the error channel associated with the watcher.
-// It will stream a list of all errors created while watching.
+// It will stream a list of all errors created while watching, such as
+// those reported by inotify, and is closed once the watcher has stopped.
 func (f *FileWatcher) ErrChan() chan error {
-	return f.watcher.Errors
+	return f.status.ErrChan()
+}
+
+// Retrieve a channel which is closed once the watcher has stopped and
+// won't invoke its handler anymore.
+func (f *FileWatcher) Done() <-chan struct{} {
+	return f.status.Done()
 }
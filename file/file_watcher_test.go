@@ -0,0 +1,129 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caoimhechaos/go-file"
+)
+
+// How long to wait for watchers to report the initial state.
+const watchTimeout = 10 * time.Second
+
+// Watch "u" with the options "opts" until the events for "n" files have
+// been reported, and return those events as URLs and names.
+func collectEvents(t *testing.T, u *url.URL, n int,
+	opts ...file.WatchOption) []string {
+	var registry *file.Registry = file.NewRegistry()
+	var events chan *file.Event = make(chan *file.Event, n)
+	var watcher file.Watcher
+	var ret []string
+	var err error
+
+	t.Helper()
+
+	registry.RegisterScheme("file", &FileFileSystemIntegration{},
+		&FileWatcherCreator{})
+
+	watcher, err = registry.WatchEvents(u, func(ev *file.Event) {
+		events <- ev
+	}, opts...)
+	if err != nil {
+		t.Fatalf("WatchEvents(%s): %s", u, err)
+	}
+	defer watcher.Shutdown()
+
+	for len(ret) < n {
+		var ev *file.Event
+
+		select {
+		case ev = <-events:
+			ret = append(ret, ev.URL.Path+" "+ev.Name)
+		case <-time.After(watchTimeout):
+			t.Fatalf("Watching %s: got %v after %s, expected %d events",
+				u, ret, watchTimeout, n)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Files whose names contain glob characters should be watched as they
+// are, unless glob patterns are asked for explicitly.
+func TestWatchLiteralName(t *testing.T) {
+	var dir string = t.TempDir()
+	var name string = filepath.Join(dir, "app[1].conf")
+	var got []string
+	var err error
+
+	if err = ioutil.WriteFile(name, []byte("data"), 0644); err != nil {
+		t.Fatalf("Writing %s: %s", name, err)
+	}
+
+	got = collectEvents(t, &url.URL{Scheme: "file", Path: name}, 1)
+	if got[0] != name+" " {
+		t.Errorf("Watching %s: got %q, expected an event for the file",
+			name, got[0])
+	}
+}
+
+// Events for the initial state of a directory should be reported like
+// later ones, without leading slashes in their names.
+func TestWatchGlobNames(t *testing.T) {
+	var dir string = t.TempDir()
+	var expected []string
+	var got []string
+	var name string
+	var err error
+
+	for _, name = range []string{"a.json", "b.json"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644)
+		if err != nil {
+			t.Fatalf("Writing %s: %s", name, err)
+		}
+		expected = append(expected, filepath.Join(dir, name)+" "+name)
+	}
+
+	got = collectEvents(t, &url.URL{Scheme: "file", Path: dir + "/*.json"},
+		2, file.WithGlob())
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Watching %s/*.json: got %q, expected %q", dir, got,
+			expected)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/caoimhechaos/go-file"
+	"golang.org/x/net/context"
+)
+
+// Suffix of the names of temporary files written by AtomicFileWriter. The
+// names also start with a dot, so temporary files are hidden.
+const tempSuffix = ".go-file-tmp"
+
+// Writer replacing a local file atomically. All data is written to a
+// temporary file in the same directory, which is only renamed over the
+// target once the writer is closed, so readers and watchers never see a
+// partially written file, and the previous contents survive a crash.
+// Like writing a file in place, symlinks are followed and the permissions
+// of the file are kept.
+type AtomicFileWriter struct {
+	ctx    context.Context
+	url    *url.URL
+	opts   *file.WriteOptions
+	target string
+	perm   os.FileMode
+	tmp    *os.File
+	closed bool
+}
+
+// Determine whether "name" is the name of a temporary file written by an
+// AtomicFileWriter.
+func isTempFile(name string) bool {
+	name = path.Base(name)
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempSuffix)
+}
+
+// Check whether the conditions in "opts" hold for the file "u" as it is
+// now.
+func checkPreconditions(u *url.URL, opts *file.WriteOptions) error {
+	var fi os.FileInfo
+	var err error
+
+	fi, err = os.Stat(u.Path)
+	if os.IsNotExist(err) {
+		if opts.IfPresent || opts.IfMatch != "" {
+			return file.NewError("write", u, file.ErrPreconditionFailed, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return file.WrapError("write", u, err)
+	}
+
+	if opts.IfAbsent {
+		return file.NewError("write", u, file.ErrPreconditionFailed, nil)
+	}
+	if opts.IfMatch != "" && fileETag(fi) != opts.IfMatch {
+		return file.NewError("write", u, file.ErrPreconditionFailed, nil)
+	}
+	return nil
+}
+
+// Create a new writer replacing the file "u" atomically once it is closed,
+// if the conditions in "opts" hold both now and by then, and "ctx" is not
+// done by then.
+func NewAtomicFileWriter(ctx context.Context, u *url.URL,
+	opts *file.WriteOptions) (*AtomicFileWriter, error) {
+	var target string = u.Path
+	var perm os.FileMode = 0644
+	var resolved string
+	var fi os.FileInfo
+	var tmp *os.File
+	var err error
+
+	if err = checkPreconditions(u, opts); err != nil {
+		return nil, err
+	}
+
+	// Replace the file a symlink points to rather than the link itself.
+	resolved, err = filepath.EvalSymlinks(u.Path)
+	if err == nil {
+		target = resolved
+	} else if !os.IsNotExist(err) {
+		return nil, file.WrapError("write", u, err)
+	}
+
+	if fi, err = os.Stat(target); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	err = os.MkdirAll(path.Dir(target), 0755)
+	if err != nil {
+		return nil, file.WrapError("write", u, err)
+	}
+
+	tmp, err = os.CreateTemp(path.Dir(target),
+		"."+path.Base(target)+".*"+tempSuffix)
+	if err != nil {
+		return nil, file.WrapError("write", u, err)
+	}
+
+	return &AtomicFileWriter{
+		ctx:    ctx,
+		url:    u,
+		opts:   opts,
+		target: target,
+		perm:   perm,
+		tmp:    tmp,
+	}, nil
+}
+
+// Write the bytes in "p" to the temporary file.
+func (w *AtomicFileWriter) Write(p []byte) (int, error) {
+	var n int
+	var err error
+
+	if w.closed {
+		return 0, file.NewError("write", w.url, nil, os.ErrClosed)
+	}
+
+	n, err = w.tmp.Write(p)
+	return n, file.WrapError("write", w.url, err)
+}
+
+// Discard the temporary file and report "err".
+func (w *AtomicFileWriter) abort(err error) error {
+	w.tmp.Close()
+	os.Remove(w.tmp.Name())
+	return err
+}
+
+// Flush the temporary file to disk and rename it over the target, then
+// flush the directory so the rename survives a crash. If the context is
+// done or the conditions the writer was opened with don't hold anymore,
+// the temporary file is discarded and the target left alone. Files which
+// must be absent are linked into place instead, so a file created in the
+// meantime is never replaced. The other conditions are checked right
+// before the rename, but not atomically with it.
+func (w *AtomicFileWriter) Close() error {
+	var err error
+
+	if w.closed {
+		return file.NewError("write", w.url, nil, os.ErrClosed)
+	}
+	w.closed = true
+
+	if err = w.ctx.Err(); err != nil {
+		return w.abort(err)
+	}
+	if err = w.tmp.Chmod(w.perm); err != nil {
+		return w.abort(file.WrapError("write", w.url, err))
+	}
+	if err = w.tmp.Sync(); err != nil {
+		return w.abort(file.WrapError("write", w.url, err))
+	}
+	if err = w.tmp.Close(); err != nil {
+		return w.abort(file.WrapError("write", w.url, err))
+	}
+
+	if w.opts.IfAbsent {
+		err = os.Link(w.tmp.Name(), w.target)
+		os.Remove(w.tmp.Name())
+		if os.IsExist(err) {
+			return file.NewError("write", w.url, file.ErrPreconditionFailed,
+				err)
+		}
+		if err != nil {
+			return file.WrapError("write", w.url, err)
+		}
+	} else {
+		if err = checkPreconditions(w.url, w.opts); err != nil {
+			return w.abort(err)
+		}
+		if err = os.Rename(w.tmp.Name(), w.target); err != nil {
+			return w.abort(file.WrapError("write", w.url, err))
+		}
+	}
+
+	return file.WrapError("write", w.url, syncDir(path.Dir(w.target)))
+}
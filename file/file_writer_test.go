@@ -0,0 +1,116 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caoimhechaos/go-file"
+	"golang.org/x/net/context"
+)
+
+// Replace the file "name" with "data" using an AtomicFileWriter.
+func writeAtomically(t *testing.T, name string, data string) {
+	var u *url.URL = &url.URL{Scheme: "file", Path: name}
+	var w *AtomicFileWriter
+	var err error
+
+	t.Helper()
+
+	w, err = NewAtomicFileWriter(context.Background(), u,
+		file.NewWriteOptions())
+	if err != nil {
+		t.Fatalf("NewAtomicFileWriter(%s): %s", u, err)
+	}
+	if _, err = w.Write([]byte(data)); err != nil {
+		t.Fatalf("Write(%s): %s", u, err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close(%s): %s", u, err)
+	}
+}
+
+// Atomic writes should keep the permissions of the file they replace.
+func TestAtomicFileWriterKeepsPermissions(t *testing.T) {
+	var name string = filepath.Join(t.TempDir(), "secret")
+	var fi os.FileInfo
+	var err error
+
+	if err = ioutil.WriteFile(name, []byte("old"), 0600); err != nil {
+		t.Fatalf("Writing %s: %s", name, err)
+	}
+	writeAtomically(t, name, "new")
+
+	if fi, err = os.Stat(name); err != nil {
+		t.Fatalf("Stat(%s): %s", name, err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("Permissions of %s: got %s, expected %s", name,
+			fi.Mode().Perm(), os.FileMode(0600))
+	}
+}
+
+// Atomic writes to a symlink should replace the file it points to, and
+// leave the link in place.
+func TestAtomicFileWriterFollowsSymlinks(t *testing.T) {
+	var dir string = t.TempDir()
+	var target string = filepath.Join(dir, "target")
+	var link string = filepath.Join(dir, "link")
+	var fi os.FileInfo
+	var data []byte
+	var err error
+
+	if err = ioutil.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatalf("Writing %s: %s", target, err)
+	}
+	if err = os.Symlink(target, link); err != nil {
+		t.Skipf("Creating symlink %s: %s", link, err)
+	}
+	writeAtomically(t, link, "new")
+
+	if fi, err = os.Lstat(link); err != nil {
+		t.Fatalf("Lstat(%s): %s", link, err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("%s was replaced instead of the file it points to", link)
+	}
+	if data, err = ioutil.ReadFile(target); err != nil {
+		t.Fatalf("Reading %s: %s", target, err)
+	}
+	if string(data) != "new" {
+		t.Errorf("Contents of %s: got %q, expected %q", target, data, "new")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"os"
+	"time"
+)
+
+// Backend-neutral description of a file, as returned by Stat. FileInfo
+// implements os.FileInfo; any backend specific details are available
+// through Sys().
+type FileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	sys     interface{}
+}
+
+// Create a new FileInfo for a file called "name". "sys" can be used to
+// pass backend specific information about the file to the caller.
+func NewFileInfo(name string, size int64, mode os.FileMode,
+	modTime time.Time, sys interface{}) *FileInfo {
+	return &FileInfo{
+		name:    name,
+		size:    size,
+		mode:    mode,
+		modTime: modTime,
+		sys:     sys,
+	}
+}
+
+// Base name of the file.
+func (f *FileInfo) Name() string {
+	return f.name
+}
+
+// Length of the file contents in bytes.
+func (f *FileInfo) Size() int64 {
+	return f.size
+}
+
+// File mode bits. Backends without a notion of permissions will report
+// something sensible, like 0644 for files and 0755 for directories.
+func (f *FileInfo) Mode() os.FileMode {
+	return f.mode
+}
+
+// Time of the last modification of the file. This is the zero time on
+// backends which don't keep track of modification times.
+func (f *FileInfo) ModTime() time.Time {
+	return f.modTime
+}
+
+// Determine whether the file is a directory.
+func (f *FileInfo) IsDir() bool {
+	return f.mode.IsDir()
+}
+
+// Backend specific information about the file, or nil.
+func (f *FileInfo) Sys() interface{} {
+	return f.sys
+}
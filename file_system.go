@@ -33,16 +33,19 @@
 package file
 
 import (
-	"errors"
 	"io"
 	"net/url"
+	"os"
+
+	"golang.org/x/net/context"
 )
 
-var FS_OperationNotImplementedError error = errors.New("Operation not implemented for this file system")
+var FS_OperationNotImplementedError error = unsupportedError{}
 
 // Object providing all relevant operations for file systems. The individual
 // file system backend implementations need to handle these properly, or
-// return a FS_OperationNotImplementedError.
+// return a FS_OperationNotImplementedError. Errors should be reported as
+// *Error, with the backend's native errors mapped to one of the Err* kinds.
 type FileSystem interface {
 	Open(*url.URL) (io.ReadCloser, error)
 	OpenForWrite(*url.URL) (io.WriteCloser, error)
@@ -52,80 +55,193 @@ type FileSystem interface {
 	Remove(*url.URL) error
 }
 
-// List of URL schema handlers known.
-var fileSystemHandlers map[string]FileSystem = make(map[string]FileSystem)
+// File systems which can additionally take a context for all of their
+// operations, which can be used to cancel them or to impose a deadline.
+// Where a reader or writer is returned, the context also applies to all
+// operations on it. For watchers, the context determines how long the
+// watch will run; it is shut down once the context is done.
+//
+// File systems not implementing this interface will have their regular
+// FileSystem methods invoked instead.
+type ContextFileSystem interface {
+	OpenContext(context.Context, *url.URL) (io.ReadCloser, error)
+	OpenForWriteContext(context.Context, *url.URL) (io.WriteCloser, error)
+	OpenForAppendContext(context.Context, *url.URL) (io.WriteCloser, error)
+	ListContext(context.Context, *url.URL) ([]string, error)
+	WatchContext(context.Context, *url.URL, func(string, io.ReadCloser)) (
+		Watcher, error)
+	RemoveContext(context.Context, *url.URL) error
+}
+
+// File systems which can retrieve metadata about a file, such as its size
+// and modification time, without opening it. The FileInfo returned should
+// be a *FileInfo or another os.FileInfo, and any backend specific details
+// should be made available through its Sys() method.
+type StatFileSystem interface {
+	StatContext(context.Context, *url.URL) (os.FileInfo, error)
+}
+
+// File systems which can natively rename a file to another URL on the same
+// file system. Renaming should be atomic. If the file can't be renamed
+// natively (e.g. because the target is on a different device), the file
+// system should return FS_OperationNotImplementedError so the file can be
+// copied instead.
+type RenameFileSystem interface {
+	RenameContext(ctx context.Context, src, dst *url.URL) error
+}
+
+// File systems which can natively copy a file to another URL on the same
+// file system. Copying should be atomic. If the file can't be copied
+// natively, the file system should return FS_OperationNotImplementedError
+// so the file can be copied by streaming its contents instead.
+type CopyFileSystem interface {
+	CopyContext(ctx context.Context, src, dst *url.URL) error
+}
+
+// File systems which can find the files matching a glob pattern natively,
+// e.g. by restricting a listing to the PatternPrefix of the pattern on the
+// server. The URLs returned must be those of the files matching the pattern
+// according to MatchPattern, sorted by their string representation. File
+// systems may return FS_OperationNotImplementedError for patterns they
+// can't expand, in which case the directories are listed instead.
+type GlobFileSystem interface {
+	GlobContext(ctx context.Context, pattern *url.URL) ([]*url.URL, error)
+}
+
+// File systems which can take WriteOptions into account when opening a file
+// for writing, i.e. which can write files conditionally. If a condition
+// doesn't hold, an error of the kind ErrPreconditionFailed must be returned,
+// either right away or from Close() on the writer, and the file must be left
+// as it was. Likewise, file systems must only accept the Atomic option if
+// they can replace files atomically, and must reject a TTL with
+// ErrUnsupported unless they can expire files. Conditional, atomic or
+// expiring writes on file systems not implementing this interface fail
+// with FS_OperationNotImplementedError.
+type WriteOptionsFileSystem interface {
+	OpenForWriteOptionsContext(context.Context, *url.URL, *WriteOptions) (
+		io.WriteCloser, error)
+}
 
 // Register "fs" as a file system implementation for all URLs with the given
-// "schema".
+// "schema". Any file system previously registered for "schema" is replaced.
 func RegisterFileSystem(schema string, fs FileSystem) {
-	fileSystemHandlers[schema] = fs
+	DefaultRegistry.RegisterFileSystem(schema, fs)
+}
+
+// Remove the file system implementation registered for "schema", if any.
+func UnregisterFileSystem(schema string) {
+	DefaultRegistry.UnregisterFileSystem(schema)
+}
+
+// Atomically replace both the file system implementation and the watcher
+// creator for "schema" by "fs" and "creator". Either of them may be nil, in
+// which case the respective handler is removed. There is no point in time
+// at which a mix of old and new handlers, or none at all, will be used for
+// the schema, so this can be used e.g. to rotate credentials. The handlers
+// which were registered previously are returned, so they can be cleaned up.
+func RegisterScheme(schema string, fs FileSystem, creator WatcherCreator) (
+	oldFs FileSystem, oldCreator WatcherCreator) {
+	return DefaultRegistry.RegisterScheme(schema, fs, creator)
+}
+
+// Get a sorted list of all schemas for which either a file system or a
+// watcher has been registered.
+func RegisteredSchemes() []string {
+	return DefaultRegistry.RegisteredSchemes()
 }
 
 // Watch the given "fileurl" for changes, sending all of them to the specified
 // "handler". This will look up the required handler for the scheme specified
-// in the URL and forward the watch request. A Watcher object is returned
+// in the URL and forward the watch request. A DoneWatcher is returned
 // which can be used to stop watching, as defined by the individual watchers.
-func Watch(fileurl *url.URL, handler func(string, io.ReadCloser)) (Watcher, error) {
-	var creator WatcherCreator
-	var fs FileSystem
-	var ok bool
+// If the file system can't watch files natively, it is polled for changes.
+// With WithGlob, all files matching the glob pattern in "fileurl" are
+// watched.
+func Watch(fileurl *url.URL, handler func(string, io.ReadCloser),
+	opts ...WatchOption) (DoneWatcher, error) {
+	return DefaultRegistry.Watch(fileurl, handler, opts...)
+}
 
-	// Prefer the full-filesystem implementation if there is one.
-	fs, ok = fileSystemHandlers[fileurl.Scheme]
-	if ok {
-		return fs.Watch(fileurl, handler)
-	}
+// Like Watch, but the watcher will also be shut down once "ctx" is done.
+func WatchContext(ctx context.Context, fileurl *url.URL,
+	handler func(string, io.ReadCloser), opts ...WatchOption) (
+	DoneWatcher, error) {
+	return DefaultRegistry.WatchContext(ctx, fileurl, handler, opts...)
+}
 
-	// Otherwise, try to find a simple watcher implementation.
-	creator, ok = fileWatcherHandlers[fileurl.Scheme]
-	if ok {
-		return creator.Watch(fileurl, handler)
-	}
+// Watch the given "fileurl" for changes, sending an Event for each of them
+// to "handler". This works like Watch, but handlers can tell apart the
+// different kinds of changes.
+func WatchEvents(fileurl *url.URL, handler func(*Event),
+	opts ...WatchOption) (DoneWatcher, error) {
+	return DefaultRegistry.WatchEvents(fileurl, handler, opts...)
+}
+
+// Like WatchEvents, but the watcher will also be shut down once "ctx" is
+// done.
+func WatchEventsContext(ctx context.Context, fileurl *url.URL,
+	handler func(*Event), opts ...WatchOption) (DoneWatcher, error) {
+	return DefaultRegistry.WatchEventsContext(ctx, fileurl, handler, opts...)
+}
 
-	return nil, FS_OperationNotImplementedError
+// Watch the given "fileurl" for changes, delivering an Event for each of
+// them on the channel returned. Errors are delivered in-band as events with
+// Err set. The channel is closed once "ctx" is done, which callers must
+// arrange for eventually.
+func WatchChan(ctx context.Context, fileurl *url.URL,
+	opts ...WatchOption) <-chan *Event {
+	return DefaultRegistry.WatchChan(ctx, fileurl, opts...)
 }
 
 // Read all names under the given path as file names. Requires "u" to point
 // to a directory. The list of file names returned should only be short,
 // local names which can be appended to the URL to form a new one.
 func List(u *url.URL) ([]string, error) {
-	var fs FileSystem
-	var ok bool
+	return DefaultRegistry.List(u)
+}
+
+// Like List, but the operation will be aborted once "ctx" is done.
+func ListContext(ctx context.Context, u *url.URL) ([]string, error) {
+	return DefaultRegistry.ListContext(ctx, u)
+}
 
-	fs, ok = fileSystemHandlers[u.Scheme]
-	if ok {
-		return fs.List(u)
-	}
+// Find all files matching the glob pattern "pattern". Each segment of the
+// path is matched using path.Match, and "**" matches any number of segments.
+// To watch the files matching a pattern, pass it to Watch with WithGlob.
+func Glob(pattern *url.URL) ([]*url.URL, error) {
+	return DefaultRegistry.Glob(pattern)
+}
 
-	return nil, FS_OperationNotImplementedError
+// Like Glob, but the operation will be aborted once "ctx" is done.
+func GlobContext(ctx context.Context, pattern *url.URL) ([]*url.URL, error) {
+	return DefaultRegistry.GlobContext(ctx, pattern)
 }
 
 // Return a reader for the file given as "u".
 func Open(u *url.URL) (io.ReadCloser, error) {
-	var fs FileSystem
-	var ok bool
-
-	fs, ok = fileSystemHandlers[u.Scheme]
-	if ok {
-		return fs.Open(u)
-	}
+	return DefaultRegistry.Open(u)
+}
 
-	return nil, FS_OperationNotImplementedError
+// Like Open, but the operation will be aborted once "ctx" is done. On file
+// systems supporting it, "ctx" will also apply to reading from the file.
+func OpenContext(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	return DefaultRegistry.OpenContext(ctx, u)
 }
 
 // Return a writer for the file given as "u". Any writer should
 // guarantee that all data has been written by the time Close()
 // returns without an error. No other guarantees have to be given.
-func OpenForWrite(u *url.URL) (io.WriteCloser, error) {
-	var fs FileSystem
-	var ok bool
-
-	fs, ok = fileSystemHandlers[u.Scheme]
-	if ok {
-		return fs.OpenForWrite(u)
-	}
+// The file will only be written if the conditions in "opts" hold.
+func OpenForWrite(u *url.URL, opts ...WriteOption) (io.WriteCloser, error) {
+	return DefaultRegistry.OpenForWrite(u, opts...)
+}
 
-	return nil, FS_OperationNotImplementedError
+// Like OpenForWrite, but the operation will be aborted once "ctx" is done.
+// On file systems supporting it, "ctx" will also apply to writing to the
+// file, up to and including Close().
+func OpenForWriteContext(ctx context.Context, u *url.URL,
+	opts ...WriteOption) (io.WriteCloser, error) {
+	return DefaultRegistry.OpenForWriteContext(ctx, u, opts...)
 }
 
 // Return a writer for appending data to the file given as "u". Any
@@ -133,28 +249,66 @@ func OpenForWrite(u *url.URL) (io.WriteCloser, error) {
 // Close() returns without an error. No other guarantees have to be
 // given.
 func OpenForAppend(u *url.URL) (io.WriteCloser, error) {
-	var fs FileSystem
-	var ok bool
-
-	fs, ok = fileSystemHandlers[u.Scheme]
-	if ok {
-		return fs.OpenForAppend(u)
-	}
+	return DefaultRegistry.OpenForAppend(u)
+}
 
-	return nil, FS_OperationNotImplementedError
+// Like OpenForAppend, but the operation will be aborted once "ctx" is done.
+// On file systems supporting it, "ctx" will also apply to writing to the
+// file, up to and including Close().
+func OpenForAppendContext(ctx context.Context, u *url.URL) (
+	io.WriteCloser, error) {
+	return DefaultRegistry.OpenForAppendContext(ctx, u)
 }
 
 // Remove the referenced object from the file system. This would cause
 // the file to be deleted from the underlying file system, or whatever
 // operation is equivalent to that.
 func Remove(u *url.URL) error {
-	var fs FileSystem
-	var ok bool
+	return DefaultRegistry.Remove(u)
+}
 
-	fs, ok = fileSystemHandlers[u.Scheme]
-	if ok {
-		return fs.Remove(u)
-	}
+// Like Remove, but the operation will be aborted once "ctx" is done.
+func RemoveContext(ctx context.Context, u *url.URL) error {
+	return DefaultRegistry.RemoveContext(ctx, u)
+}
+
+// Retrieve metadata about the file given as "u", such as its size and
+// modification time, without opening it.
+func Stat(u *url.URL) (os.FileInfo, error) {
+	return DefaultRegistry.Stat(u)
+}
+
+// Like Stat, but the operation will be aborted once "ctx" is done.
+func StatContext(ctx context.Context, u *url.URL) (os.FileInfo, error) {
+	return DefaultRegistry.StatContext(ctx, u)
+}
+
+// Move the file "src" to "dst". If both are on the same file system and it
+// supports renaming files natively, that will be used. Otherwise, the
+// contents will be copied to "dst" and "src" will be removed afterwards.
+// "atomic" reports whether the file was renamed atomically; this is never
+// the case when falling back to copying.
+func Rename(src, dst *url.URL) (atomic bool, err error) {
+	return DefaultRegistry.Rename(src, dst)
+}
+
+// Like Rename, but the operation will be aborted once "ctx" is done.
+func RenameContext(ctx context.Context, src, dst *url.URL) (
+	atomic bool, err error) {
+	return DefaultRegistry.RenameContext(ctx, src, dst)
+}
+
+// Copy the contents of the file "src" to "dst". If both are on the same
+// file system and it supports copying files natively, that will be used.
+// Otherwise, the contents will be streamed from "src" to "dst". "atomic"
+// reports whether the file was copied atomically; this is never the case
+// when falling back to streaming.
+func Copy(src, dst *url.URL) (atomic bool, err error) {
+	return DefaultRegistry.Copy(src, dst)
+}
 
-	return FS_OperationNotImplementedError
+// Like Copy, but the operation will be aborted once "ctx" is done.
+func CopyContext(ctx context.Context, src, dst *url.URL) (
+	atomic bool, err error) {
+	return DefaultRegistry.CopyContext(ctx, src, dst)
 }
@@ -0,0 +1,577 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// Conformance tests for go-file file system implementations. The tests
+// define the behavior all file systems should agree on, so code using
+// go-file doesn't have to care about which backend it is talking to.
+//
+// Backends can run the tests from their own test code:
+//
+//	func TestConformance(t *testing.T) {
+//		base, _ := url.Parse("mem:///fstest")
+//		fstest.TestFileSystem(t, mem.NewMemFileSystem(), base)
+//	}
+package fstest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/caoimhechaos/go-file"
+	"golang.org/x/net/context"
+)
+
+// How long to wait for watchers to deliver notifications or shut down.
+const WatchTimeout = 10 * time.Second
+
+// Run the conformance tests against the file system "fs". All files will
+// be created below "base", which should point to an empty directory; it
+// will be removed again at the end. Tests for operations which are not
+// implemented by the file system are skipped.
+func TestFileSystem(t *testing.T, fs file.FileSystem, base *url.URL) {
+	var registry *file.Registry = file.NewRegistry()
+
+	registry.RegisterFileSystem(base.Scheme, fs)
+	t.Cleanup(func() {
+		removeAll(registry, base)
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		testRoundTrip(t, registry, join(base, "roundtrip"))
+	})
+	t.Run("Truncate", func(t *testing.T) {
+		testTruncate(t, registry, join(base, "truncate"))
+	})
+	t.Run("Append", func(t *testing.T) {
+		testAppend(t, registry, join(base, "append"))
+	})
+	t.Run("OpenMissing", func(t *testing.T) {
+		testOpenMissing(t, registry, join(base, "openmissing"))
+	})
+	t.Run("List", func(t *testing.T) {
+		testList(t, registry, join(base, "list"))
+	})
+	t.Run("Remove", func(t *testing.T) {
+		testRemove(t, registry, join(base, "remove"))
+	})
+	t.Run("Stat", func(t *testing.T) {
+		testStat(t, registry, join(base, "stat"))
+	})
+	t.Run("OntoSelf", func(t *testing.T) {
+		testOntoSelf(t, registry, join(base, "ontoself"))
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		testCancel(t, registry, join(base, "cancel"))
+	})
+	t.Run("Watch", func(t *testing.T) {
+		testWatch(t, registry, join(base, "watch"))
+	})
+	t.Run("ConditionalWrite", func(t *testing.T) {
+		testConditionalWrite(t, registry, join(base, "conditional"))
+	})
+}
+
+// Build a new URL by appending the path elements "names" to "u".
+func join(u *url.URL, names ...string) *url.URL {
+	var ret url.URL = *u
+
+	ret.Path = path.Join(append([]string{u.Path}, names...)...)
+	return &ret
+}
+
+// Remove "u" and, if it is a directory, everything below it. Errors are
+// ignored, since this is only used for cleaning up after the tests.
+func removeAll(r *file.Registry, u *url.URL) {
+	var names []string
+	var name string
+	var err error
+
+	names, err = r.List(u)
+	if err == nil {
+		for _, name = range names {
+			removeAll(r, join(u, name))
+		}
+	}
+	r.Remove(u)
+}
+
+// Skip the test if "err" reports that the operation is not implemented.
+func skipUnsupported(t *testing.T, op string, err error) {
+	t.Helper()
+
+	if errors.Is(err, file.ErrUnsupported) {
+		t.Skipf("%s is not implemented: %s", op, err)
+	}
+}
+
+// Write "data" to the file "u", replacing its previous contents.
+func writeFile(t *testing.T, r *file.Registry, u *url.URL, data []byte) {
+	var wc io.WriteCloser
+	var err error
+
+	t.Helper()
+
+	wc, err = r.OpenForWrite(u)
+	skipUnsupported(t, "OpenForWrite", err)
+	if err != nil {
+		t.Fatalf("OpenForWrite(%s): %s", u, err)
+	}
+
+	if _, err = wc.Write(data); err != nil {
+		wc.Close()
+		t.Fatalf("Write(%s): %s", u, err)
+	}
+
+	if err = wc.Close(); err != nil {
+		t.Fatalf("Close(%s) after writing: %s", u, err)
+	}
+}
+
+// Write "data" to the file "u" if the conditions in "opts" hold. Errors
+// are returned no matter whether they occur when opening, writing or
+// closing the file.
+func writeConditionally(r *file.Registry, u *url.URL, data []byte,
+	opts ...file.WriteOption) error {
+	var wc io.WriteCloser
+	var err error
+
+	wc, err = r.OpenForWrite(u, opts...)
+	if err != nil {
+		return err
+	}
+
+	if _, err = wc.Write(data); err != nil {
+		wc.Close()
+		return err
+	}
+	return wc.Close()
+}
+
+// Read the contents of the file "u". The data is read one byte at a time
+// to verify readers copy data into the caller's buffer correctly.
+func readFile(r *file.Registry, u *url.URL) ([]byte, error) {
+	var rc io.ReadCloser
+	var data []byte
+	var err error
+
+	rc, err = r.Open(u)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = ioutil.ReadAll(iotest.OneByteReader(rc))
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return data, rc.Close()
+}
+
+// Verify that the contents of the file "u" are "expected".
+func checkContents(t *testing.T, r *file.Registry, u *url.URL,
+	expected []byte) {
+	var data []byte
+	var err error
+
+	t.Helper()
+
+	data, err = readFile(r, u)
+	if err != nil {
+		t.Fatalf("Reading %s: %s", u, err)
+	}
+
+	if !bytes.Equal(data, expected) {
+		t.Errorf("Contents of %s: got %q, expected %q", u, data, expected)
+	}
+}
+
+// Data written should be read back unchanged.
+func testRoundTrip(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "file")
+	var data []byte = []byte("Hello, world!\n\x00\xff binary data")
+
+	writeFile(t, r, u, data)
+	checkContents(t, r, u, data)
+}
+
+// OpenForWrite should replace the previous contents of a file entirely.
+func testTruncate(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "file")
+
+	writeFile(t, r, u, []byte("a rather long line of text"))
+	writeFile(t, r, u, []byte("short"))
+	checkContents(t, r, u, []byte("short"))
+}
+
+// OpenForAppend should add to the previous contents of a file.
+func testAppend(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "file")
+	var wc io.WriteCloser
+	var err error
+
+	writeFile(t, r, u, []byte("first"))
+
+	wc, err = r.OpenForAppend(u)
+	skipUnsupported(t, "OpenForAppend", err)
+	if err != nil {
+		t.Fatalf("OpenForAppend(%s): %s", u, err)
+	}
+
+	if _, err = wc.Write([]byte(", second")); err != nil {
+		wc.Close()
+		t.Fatalf("Write(%s): %s", u, err)
+	}
+
+	if err = wc.Close(); err != nil {
+		t.Fatalf("Close(%s) after appending: %s", u, err)
+	}
+
+	checkContents(t, r, u, []byte("first, second"))
+}
+
+// Opening a file which doesn't exist should report file.ErrNotExist. Since
+// readers may fetch data lazily, the error may also be reported by Read.
+func testOpenMissing(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "missing")
+	var err error
+
+	_, err = readFile(r, u)
+	if !errors.Is(err, file.ErrNotExist) {
+		t.Errorf("Reading missing file %s: got error %v, expected %v",
+			u, err, file.ErrNotExist)
+	}
+}
+
+// List should return the short names of all entries in a directory, i.e.
+// names which can be appended to the directory URL.
+func testList(t *testing.T, r *file.Registry, base *url.URL) {
+	var dir *url.URL = join(base, "dir")
+	var expected []string = []string{"a", "b", "sub"}
+	var names []string
+	var err error
+
+	writeFile(t, r, join(dir, "a"), []byte("a"))
+	writeFile(t, r, join(dir, "b"), []byte("b"))
+	writeFile(t, r, join(dir, "sub", "c"), []byte("c"))
+
+	names, err = r.List(dir)
+	skipUnsupported(t, "List", err)
+	if err != nil {
+		t.Fatalf("List(%s): %s", dir, err)
+	}
+
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("List(%s): got %v, expected %v", dir, names, expected)
+	}
+}
+
+// Removed files should be gone, and removing files which don't exist
+// should report file.ErrNotExist.
+func testRemove(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "file")
+	var err error
+
+	writeFile(t, r, u, []byte("doomed"))
+
+	err = r.Remove(u)
+	skipUnsupported(t, "Remove", err)
+	if err != nil {
+		t.Fatalf("Remove(%s): %s", u, err)
+	}
+
+	if _, err = readFile(r, u); !errors.Is(err, file.ErrNotExist) {
+		t.Errorf("Reading removed file %s: got error %v, expected %v",
+			u, err, file.ErrNotExist)
+	}
+
+	if err = r.Remove(u); !errors.Is(err, file.ErrNotExist) {
+		t.Errorf("Remove(%s) of missing file: got error %v, expected %v",
+			u, err, file.ErrNotExist)
+	}
+}
+
+// Stat should report the size of files, tell directories apart from files
+// and report file.ErrNotExist for missing files.
+func testStat(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "dir", "file")
+	var fi os.FileInfo
+	var err error
+
+	writeFile(t, r, u, []byte("12345"))
+
+	fi, err = r.Stat(u)
+	skipUnsupported(t, "Stat", err)
+	if err != nil {
+		t.Fatalf("Stat(%s): %s", u, err)
+	}
+	if fi.Size() != 5 || fi.IsDir() {
+		t.Errorf("Stat(%s): got size %d, directory %v, expected 5, false",
+			u, fi.Size(), fi.IsDir())
+	}
+
+	fi, err = r.Stat(join(base, "dir"))
+	if err != nil {
+		t.Fatalf("Stat(%s): %s", join(base, "dir"), err)
+	}
+	if !fi.IsDir() {
+		t.Errorf("Stat(%s): not reported as a directory", join(base, "dir"))
+	}
+
+	if _, err = r.Stat(join(base, "missing")); !errors.Is(err, file.ErrNotExist) {
+		t.Errorf("Stat(%s) of missing file: got error %v, expected %v",
+			join(base, "missing"), err, file.ErrNotExist)
+	}
+}
+
+// Copying or renaming a file onto itself should leave it as it is, and
+// should report file.ErrNotExist if the file doesn't exist.
+func testOntoSelf(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "file")
+	var missing *url.URL = join(base, "missing")
+	var err error
+
+	writeFile(t, r, u, []byte("stays"))
+
+	if _, err = r.Copy(u, u); err != nil {
+		t.Errorf("Copy(%s) onto itself: %s", u, err)
+	}
+	if _, err = r.Rename(u, u); err != nil {
+		t.Errorf("Rename(%s) onto itself: %s", u, err)
+	}
+	checkContents(t, r, u, []byte("stays"))
+
+	if _, err = r.Copy(missing, missing); !errors.Is(err, file.ErrNotExist) {
+		t.Errorf("Copy(%s) of missing file onto itself: got error %v, "+
+			"expected %v", missing, err, file.ErrNotExist)
+	}
+	if _, err = r.Rename(missing, missing); !errors.Is(err, file.ErrNotExist) {
+		t.Errorf("Rename(%s) of missing file onto itself: got error %v, "+
+			"expected %v", missing, err, file.ErrNotExist)
+	}
+}
+
+// Operations with a context which is already done should fail.
+func testCancel(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "file")
+	var created *url.URL = join(base, "created")
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var rc io.ReadCloser
+	var wc io.WriteCloser
+	var err error
+
+	writeFile(t, r, u, []byte("data"))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	// Readers may only consult the context once they are read from.
+	if rc, err = r.OpenContext(ctx, u); err == nil {
+		_, err = ioutil.ReadAll(rc)
+		rc.Close()
+	}
+	if err == nil {
+		t.Errorf("Reading %s through OpenContext succeeded with a "+
+			"cancelled context", u)
+	}
+
+	// Likewise, writers may only consult the context when closed.
+	if wc, err = r.OpenForWriteContext(ctx, created); err == nil {
+		if _, err = wc.Write([]byte("data")); err == nil {
+			err = wc.Close()
+		} else {
+			wc.Close()
+		}
+	}
+	if err == nil {
+		t.Errorf("Writing %s through OpenForWriteContext succeeded with "+
+			"a cancelled context", created)
+	}
+
+	if _, err = r.ListContext(ctx, base); err == nil {
+		t.Errorf("ListContext(%s) succeeded with a cancelled context", base)
+	}
+	if err = r.RemoveContext(ctx, u); err == nil {
+		t.Errorf("RemoveContext(%s) succeeded with a cancelled context", u)
+	}
+	checkContents(t, r, u, []byte("data"))
+}
+
+// Watchers should deliver changes to the watched file, and stop doing so
+// once they have been shut down.
+func testWatch(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "file")
+	var changes chan string = make(chan string, 100)
+	var done chan error = make(chan error, 1)
+	var watcher file.DoneWatcher
+	var timeout <-chan time.Time
+	var err error
+
+	writeFile(t, r, u, []byte("initial"))
+
+	watcher, err = r.Watch(u, func(name string, rc io.ReadCloser) {
+		var data []byte
+
+		data, _ = ioutil.ReadAll(rc)
+		rc.Close()
+		changes <- string(data)
+	})
+	skipUnsupported(t, "Watch", err)
+	if err != nil {
+		t.Fatalf("Watch(%s): %s", u, err)
+	}
+
+	// Watchers may take a moment to get started, so keep modifying the
+	// file until the change is noticed.
+	timeout = time.After(WatchTimeout)
+	writeFile(t, r, u, []byte("modified"))
+	for found := false; !found; {
+		select {
+		case data := <-changes:
+			found = data == "modified"
+		case <-time.After(WatchTimeout / 10):
+			writeFile(t, r, u, []byte("modified"))
+		case <-timeout:
+			watcher.Shutdown()
+			t.Fatalf("No notification for change of %s within %s", u,
+				WatchTimeout)
+		}
+	}
+
+	go func() {
+		done <- watcher.Shutdown()
+	}()
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("Shutdown() of watcher for %s: %s", u, err)
+		}
+	case <-time.After(WatchTimeout):
+		t.Fatalf("Shutdown() of watcher for %s took longer than %s", u,
+			WatchTimeout)
+	}
+
+	select {
+	case <-watcher.Done():
+	case <-time.After(WatchTimeout):
+		t.Fatalf("Watcher for %s not done within %s of Shutdown()", u,
+			WatchTimeout)
+	}
+
+	// The error channel must be closed once the watcher is done.
+	for err = range watcher.ErrChan() {
+		t.Logf("Error watching %s: %s", u, err)
+	}
+
+	// Drain any notifications which were delivered during shutdown.
+	for len(changes) > 0 {
+		<-changes
+	}
+
+	writeFile(t, r, u, []byte("after shutdown"))
+	select {
+	case data := <-changes:
+		t.Errorf("Notification with %q for %s after Shutdown()", data, u)
+	case <-time.After(WatchTimeout / 20):
+	}
+}
+
+// Files should only be written if the conditions given hold, and be left
+// alone otherwise.
+func testConditionalWrite(t *testing.T, r *file.Registry, base *url.URL) {
+	var u *url.URL = join(base, "file")
+	var fi os.FileInfo
+	var etag string
+	var err error
+
+	err = writeConditionally(r, u, []byte("present"), file.WithIfPresent())
+	skipUnsupported(t, "Conditional OpenForWrite", err)
+	if !errors.Is(err, file.ErrPreconditionFailed) {
+		t.Fatalf("Writing missing %s if present: got error %v, expected %v",
+			u, err, file.ErrPreconditionFailed)
+	}
+	if _, err = readFile(r, u); !errors.Is(err, file.ErrNotExist) {
+		t.Fatalf("Reading %s after failed write: got error %v, expected %v",
+			u, err, file.ErrNotExist)
+	}
+
+	err = writeConditionally(r, u, []byte("first"), file.WithIfAbsent())
+	if err != nil {
+		t.Fatalf("Writing missing %s if absent: %s", u, err)
+	}
+	err = writeConditionally(r, u, []byte("absent"), file.WithIfAbsent())
+	if !errors.Is(err, file.ErrPreconditionFailed) {
+		t.Errorf("Writing existing %s if absent: got error %v, expected %v",
+			u, err, file.ErrPreconditionFailed)
+	}
+	checkContents(t, r, u, []byte("first"))
+
+	err = writeConditionally(r, u, []byte("second"), file.WithIfPresent())
+	if err != nil {
+		t.Fatalf("Writing existing %s if present: %s", u, err)
+	}
+	checkContents(t, r, u, []byte("second"))
+
+	fi, err = r.Stat(u)
+	if errors.Is(err, file.ErrUnsupported) {
+		return
+	}
+	if err != nil {
+		t.Fatalf("Stat(%s): %s", u, err)
+	}
+	if etag = file.ETag(fi); etag == "" {
+		return
+	}
+
+	err = writeConditionally(r, u, []byte("third version"),
+		file.WithIfMatch(etag))
+	if err != nil {
+		t.Fatalf("Writing %s if it matches %q: %s", u, etag, err)
+	}
+	err = writeConditionally(r, u, []byte("outdated"), file.WithIfMatch(etag))
+	if !errors.Is(err, file.ErrPreconditionFailed) {
+		t.Errorf("Writing %s if it matches outdated %q: got error %v, "+
+			"expected %v", u, etag, err, file.ErrPreconditionFailed)
+	}
+	checkContents(t, r, u, []byte("third version"))
+}
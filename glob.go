@@ -0,0 +1,257 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// Characters which make a path segment a pattern rather than a name.
+const patternMeta = `*?[\`
+
+// Report whether the path "p" contains any glob meta characters, i.e.
+// whether it is a pattern to be expanded by Glob, rather than a name.
+func IsPattern(p string) bool {
+	return strings.ContainsAny(p, patternMeta)
+}
+
+// Get the literal prefix of the pattern "p", i.e. everything before its
+// first meta character. All names matching "p" start with this prefix, so
+// backends can restrict listings and watches to it.
+func PatternPrefix(p string) string {
+	var i int = strings.IndexAny(p, patternMeta)
+
+	if i < 0 {
+		return p
+	}
+	return p[:i]
+}
+
+// Report whether the slash separated path "name" matches "pattern". Each
+// segment of the path is matched against the corresponding segment of the
+// pattern using path.Match, except for segments consisting of "**", which
+// match any number of segments. A trailing "**" matches everything below
+// the directory before it, but not the directory itself. The only possible
+// error is path.ErrBadPattern.
+func MatchPattern(pattern, name string) (bool, error) {
+	var segment string
+	var err error
+
+	for _, segment = range splitPattern(pattern) {
+		if _, err = path.Match(segment, ""); err != nil {
+			return false, err
+		}
+	}
+
+	return matchSegments(splitPattern(pattern), splitPattern(name)), nil
+}
+
+// Split the path "p" into its segments, ignoring any leading, trailing or
+// duplicate slashes.
+func splitPattern(p string) []string {
+	var ret []string
+	var segment string
+
+	for _, segment = range strings.Split(p, "/") {
+		if segment != "" && segment != "." {
+			ret = append(ret, segment)
+		}
+	}
+	return ret
+}
+
+// Match the segments of a name against the segments of a valid pattern.
+func matchSegments(pattern, name []string) bool {
+	var i int
+
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return len(name) > 0
+			}
+			for i = 0; i <= len(name); i++ {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Split the pattern "p" into the directory containing all of its matches,
+// which is made up of the segments before the first one containing meta
+// characters, and the remaining segments to be matched below it. Patterns
+// without any meta characters are split into their parent directory and
+// their last segment.
+func patternBase(p string) (dir string, rest []string) {
+	var segments []string = splitPattern(p)
+	var i int
+
+	for i = 0; i < len(segments) && !IsPattern(segments[i]); i++ {
+	}
+	if i == len(segments) && i > 0 {
+		i--
+	}
+	return "/" + strings.Join(segments[:i], "/"), segments[i:]
+}
+
+// Check all segments of the pattern "u" for syntax errors.
+func checkPattern(op string, u *url.URL) error {
+	var segment string
+	var err error
+
+	for _, segment = range splitPattern(u.Path) {
+		if _, err = path.Match(segment, ""); err != nil {
+			return NewError(op, u, nil, err)
+		}
+	}
+	return nil
+}
+
+// Get the URL of the entry "name" in the directory "dir".
+func childURL(dir *url.URL, name string) *url.URL {
+	var ret url.URL = *dir
+
+	ret.Path = path.Join(dir.Path, name)
+	return &ret
+}
+
+// Find all files below "dir" matching the pattern segments "pattern" by
+// listing the directories they may be in, adding them to "found". Errors
+// listing "dir" are only returned if "top" is set; otherwise, "dir" is
+// assumed to be a file.
+func (r *Registry) globList(ctx context.Context, dir *url.URL,
+	pattern []string, found map[string]*url.URL, top bool) error {
+	var names []string
+	var name string
+	var err error
+
+	if len(pattern) == 0 {
+		found[dir.String()] = dir
+		return nil
+	}
+
+	// "**" may match nothing at all, unless it's the last segment.
+	if pattern[0] == "**" && len(pattern) > 1 {
+		if err = r.globList(ctx, dir, pattern[1:], found, top); err != nil {
+			return err
+		}
+	}
+
+	if names, err = r.ListContext(ctx, dir); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if top && !errors.Is(err, ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	for _, name = range names {
+		var child *url.URL = childURL(dir, name)
+
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				found[child.String()] = child
+			}
+			err = r.globList(ctx, child, pattern, found, false)
+		} else if ok, _ := path.Match(pattern[0], name); ok {
+			err = r.globList(ctx, child, pattern[1:], found, false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Determine the URL to watch for changes of files matching "pattern", and
+// wrap "handler" so it only receives events for those files. The directory
+// containing all matches is watched, recursively if matches can be further
+// down the tree, so backends can restrict the watch to its prefix.
+func globWatch(pattern *url.URL, handler func(*Event)) (
+	*url.URL, func(*Event), error) {
+	var base url.URL = *pattern
+	var query url.Values
+	var rest []string
+	var err error
+
+	if err = checkPattern("watch", pattern); err != nil {
+		return nil, nil, err
+	}
+
+	base.Path, rest = patternBase(pattern.Path)
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	if len(rest) > 1 || rest[0] == "**" {
+		query = base.Query()
+		query.Set("recursive", "1")
+		base.RawQuery = query.Encode()
+	}
+
+	return &base, func(ev *Event) {
+		if ev.Err == nil && ev.URL != nil {
+			if ok, _ := MatchPattern(pattern.Path, ev.URL.Path); !ok {
+				return
+			}
+		}
+		handler(ev)
+	}, nil
+}
+
+// Sort "urls" by their string representation.
+func sortURLs(urls []*url.URL) {
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].String() < urls[j].String()
+	})
+}
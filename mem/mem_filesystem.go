@@ -0,0 +1,451 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+// In-memory file system for go-file. All files are kept in memory and
+// disappear when the process exits, which makes this useful for unit tests
+// of code using go-file, and for ephemeral data.
+package mem
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/caoimhechaos/go-file"
+	"golang.org/x/net/context"
+)
+
+// Automatically sign us up for mem:// URLs.
+func init() {
+	file.RegisterFileSystem("mem", NewMemFileSystem())
+}
+
+// Contents of an individual file. The data is never modified in place, so
+// it can be handed out to readers without copying it.
+type memFile struct {
+	data    []byte
+	modTime time.Time
+	version int64
+}
+
+// Backend specific metadata about a file in a MemFileSystem, returned by
+// the Sys() method of its FileInfo.
+type MemFileInfo struct {
+	// Version of the file. Each write to any file in the file system
+	// yields a new version, so it also changes if the file is recreated.
+	Version int64
+}
+
+// Describe the version of the file.
+func (fi *MemFileInfo) ETag() string {
+	return strconv.FormatInt(fi.Version, 10)
+}
+
+// In-memory file system. Directories are not stored explicitly; they exist
+// as long as there are files below them. A MemFileSystem is safe for
+// concurrent use.
+type MemFileSystem struct {
+	mtx      sync.RWMutex
+	files    map[string]*memFile
+	version  int64
+	watchers map[*MemWatcher]bool
+}
+
+// Create a new, empty in-memory file system. It can be registered with a
+// file.Registry to get a file system separate from the default one, e.g.
+// for each individual test.
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		files:    make(map[string]*memFile),
+		watchers: make(map[*MemWatcher]bool),
+	}
+}
+
+// Get the normalized path of the file referred to by "u".
+func cleanPath(u *url.URL) string {
+	return path.Clean("/" + u.Path)
+}
+
+// Determine whether "p" is a directory, i.e. whether there are any files
+// below it. Must be called with the lock held.
+func (m *MemFileSystem) isDir(p string) bool {
+	var name string
+	var prefix string = strings.TrimSuffix(p, "/") + "/"
+
+	if p == "/" {
+		return true
+	}
+
+	for name = range m.files {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether a file could be created at "p", i.e. that neither "p" is a
+// directory nor any of its parents is a file. Must be called with the lock
+// held.
+func (m *MemFileSystem) checkWritable(op string, u *url.URL, p string) error {
+	var dir string
+	var ok bool
+
+	if m.isDir(p) {
+		return file.NewError(op, u, nil, syscall.EISDIR)
+	}
+
+	for dir = path.Dir(p); dir != "/"; dir = path.Dir(dir) {
+		if _, ok = m.files[dir]; ok {
+			return file.NewError(op, u, file.ErrNotDir, nil)
+		}
+	}
+	return nil
+}
+
+// Replace the contents of the file "p" by "data" and notify all watchers.
+// Must be called with the write lock held.
+func (m *MemFileSystem) store(p string, data []byte) {
+	var op file.Op = file.OpCreate
+	var ok bool
+
+	if _, ok = m.files[p]; ok {
+		op = file.OpModify
+	}
+
+	m.version++
+	m.files[p] = &memFile{
+		data:    data,
+		modTime: time.Now(),
+		version: m.version,
+	}
+	m.notify(p, op, data)
+}
+
+// Notify all watchers interested in "p" that the operation "op" left it
+// with the contents "data". Must be called with the lock held.
+func (m *MemFileSystem) notify(p string, op file.Op, data []byte) {
+	var watcher *MemWatcher
+
+	for watcher = range m.watchers {
+		if watcher.matches(p) {
+			watcher.enqueue(p, op, data)
+		}
+	}
+}
+
+// Open the file pointed to by "u" for reading. The reader returned will
+// see the contents of the file at the time it was opened.
+func (m *MemFileSystem) Open(u *url.URL) (io.ReadCloser, error) {
+	return m.OpenContext(context.Background(), u)
+}
+
+// Open the file pointed to by "u" for reading, unless "ctx" is already
+// done.
+func (m *MemFileSystem) OpenContext(ctx context.Context, u *url.URL) (
+	io.ReadCloser, error) {
+	var p string = cleanPath(u)
+	var f *memFile
+	var ok bool
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	f, ok = m.files[p]
+	if !ok {
+		if m.isDir(p) {
+			return nil, file.NewError("open", u, nil, syscall.EISDIR)
+		}
+		return nil, file.NewError("open", u, file.ErrNotExist, nil)
+	}
+
+	return file.NewReadCloserFake(bytes.NewReader(f.data)), nil
+}
+
+// Open the file pointed to by "u" for writing. The file is replaced with
+// the data written when the writer is closed.
+func (m *MemFileSystem) OpenForWrite(u *url.URL) (io.WriteCloser, error) {
+	return m.OpenForWriteContext(context.Background(), u)
+}
+
+// Open the file pointed to by "u" for writing. The file is replaced with
+// the data written when the writer is closed, unless "ctx" is done by then.
+func (m *MemFileSystem) OpenForWriteContext(ctx context.Context,
+	u *url.URL) (io.WriteCloser, error) {
+	return m.openWriter(ctx, "write", u, false, file.NewWriteOptions())
+}
+
+// Open the file pointed to by "u" for writing. The file is replaced with
+// the data written when the writer is closed, if the conditions in "opts"
+// hold at that time and "ctx" isn't done by then. Otherwise, Close returns
+// an error. Files are always replaced atomically, so the Atomic option
+// makes no difference. Files can't expire, so a TTL is rejected.
+func (m *MemFileSystem) OpenForWriteOptionsContext(ctx context.Context,
+	u *url.URL, opts *file.WriteOptions) (io.WriteCloser, error) {
+	if opts.TTL > 0 {
+		return nil, file.NewError("write", u, file.ErrUnsupported, nil)
+	}
+	return m.openWriter(ctx, "write", u, false, opts)
+}
+
+// Open the file pointed to by "u" for appending. The data written is
+// appended to the file when the writer is closed.
+func (m *MemFileSystem) OpenForAppend(u *url.URL) (io.WriteCloser, error) {
+	return m.OpenForAppendContext(context.Background(), u)
+}
+
+// Open the file pointed to by "u" for appending. The data written is
+// appended to the file when the writer is closed, unless "ctx" is done by
+// then.
+func (m *MemFileSystem) OpenForAppendContext(ctx context.Context,
+	u *url.URL) (io.WriteCloser, error) {
+	return m.openWriter(ctx, "append", u, true, file.NewWriteOptions())
+}
+
+// Create a new writer for the file "u", after checking that it can be
+// written to.
+func (m *MemFileSystem) openWriter(ctx context.Context, op string,
+	u *url.URL, appending bool, opts *file.WriteOptions) (io.WriteCloser,
+	error) {
+	var p string = cleanPath(u)
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	err = m.checkWritable(op, u, p)
+	m.mtx.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemWriter{
+		ctx:    ctx,
+		fs:     m,
+		op:     op,
+		url:    u,
+		path:   p,
+		append: appending,
+		opts:   opts,
+	}, nil
+}
+
+// Get the names of all files and directories directly below the directory
+// "u". Only the short names are returned.
+func (m *MemFileSystem) List(u *url.URL) ([]string, error) {
+	return m.ListContext(context.Background(), u)
+}
+
+// Get the names of all files and directories directly below the directory
+// "u", unless "ctx" is already done.
+func (m *MemFileSystem) ListContext(ctx context.Context, u *url.URL) (
+	[]string, error) {
+	var p string = cleanPath(u)
+	var prefix string = strings.TrimSuffix(p, "/") + "/"
+	var names map[string]bool = make(map[string]bool)
+	var ret []string = []string{}
+	var name string
+	var ok bool
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return ret, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	if _, ok = m.files[p]; ok {
+		return ret, file.NewError("list", u, file.ErrNotDir, nil)
+	}
+	if !m.isDir(p) {
+		return ret, file.NewError("list", u, file.ErrNotExist, nil)
+	}
+
+	for name = range m.files {
+		if strings.HasPrefix(name, prefix) {
+			name = strings.SplitN(name[len(prefix):], "/", 2)[0]
+			names[name] = true
+		}
+	}
+
+	for name = range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Create a new watcher for changes to the file or directory "u".
+func (m *MemFileSystem) Watch(u *url.URL, cb func(string, io.ReadCloser)) (
+	file.Watcher, error) {
+	return m.WatchContext(context.Background(), u, cb)
+}
+
+// Create a new watcher for changes to the file or directory "u", which
+// will be shut down once "ctx" is done.
+func (m *MemFileSystem) WatchContext(ctx context.Context, u *url.URL,
+	cb func(string, io.ReadCloser)) (file.Watcher, error) {
+	return m.WatchEventsContext(ctx, u, file.CallbackEventHandler(cb))
+}
+
+// Create a new watcher delivering Events for changes to the file or
+// directory "u", which will be shut down once "ctx" is done. Directories
+// are watched recursively if the query of "u" has recursive=1.
+func (m *MemFileSystem) WatchEventsContext(ctx context.Context, u *url.URL,
+	handler func(*file.Event)) (file.Watcher, error) {
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return newMemWatcher(ctx, m, u, handler), nil
+}
+
+// Remove the file "u". Directories can be removed only if they're empty,
+// which means they don't exist anyway.
+func (m *MemFileSystem) Remove(u *url.URL) error {
+	return m.RemoveContext(context.Background(), u)
+}
+
+// Remove the file "u", unless "ctx" is already done.
+func (m *MemFileSystem) RemoveContext(ctx context.Context, u *url.URL) error {
+	var p string = cleanPath(u)
+	var ok bool
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok = m.files[p]; !ok {
+		if m.isDir(p) {
+			return file.NewError("remove", u, nil, syscall.ENOTEMPTY)
+		}
+		return file.NewError("remove", u, file.ErrNotExist, nil)
+	}
+
+	delete(m.files, p)
+	m.notify(p, file.OpDelete, nil)
+	return nil
+}
+
+// Retrieve metadata about the file or directory "u", unless "ctx" is
+// already done.
+func (m *MemFileSystem) StatContext(ctx context.Context, u *url.URL) (
+	os.FileInfo, error) {
+	var p string = cleanPath(u)
+	var f *memFile
+	var ok bool
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	if f, ok = m.files[p]; ok {
+		return file.NewFileInfo(path.Base(p), int64(len(f.data)), 0644,
+			f.modTime, &MemFileInfo{Version: f.version}), nil
+	}
+	if m.isDir(p) {
+		return file.NewFileInfo(path.Base(p), 0, os.ModeDir|0755,
+			time.Time{}, nil), nil
+	}
+	return nil, file.NewError("stat", u, file.ErrNotExist, nil)
+}
+
+// Atomically rename the file "src" to "dst".
+func (m *MemFileSystem) RenameContext(ctx context.Context,
+	src, dst *url.URL) error {
+	return m.copyFile(ctx, "rename", src, dst, true)
+}
+
+// Atomically copy the file "src" to "dst".
+func (m *MemFileSystem) CopyContext(ctx context.Context,
+	src, dst *url.URL) error {
+	return m.copyFile(ctx, "copy", src, dst, false)
+}
+
+// Copy the file "src" to "dst", removing "src" if "remove" is set.
+func (m *MemFileSystem) copyFile(ctx context.Context, op string,
+	src, dst *url.URL, remove bool) error {
+	var srcPath string = cleanPath(src)
+	var dstPath string = cleanPath(dst)
+	var f *memFile
+	var ok bool
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if f, ok = m.files[srcPath]; !ok {
+		return file.NewError(op, src, file.ErrNotExist, nil)
+	}
+	if srcPath == dstPath {
+		return nil
+	}
+	if err = m.checkWritable(op, dst, dstPath); err != nil {
+		return err
+	}
+
+	if remove {
+		delete(m.files, srcPath)
+		m.notify(srcPath, file.OpRename, nil)
+	}
+	m.store(dstPath, f.data)
+	return nil
+}
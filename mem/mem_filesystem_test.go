@@ -0,0 +1,60 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package mem
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/caoimhechaos/go-file"
+	"github.com/caoimhechaos/go-file/fstest"
+	"golang.org/x/net/context"
+)
+
+// Run the conformance tests against a fresh in-memory file system, and
+// make sure they clean up after themselves.
+func TestConformance(t *testing.T) {
+	var fs *MemFileSystem = NewMemFileSystem()
+	var base *url.URL = &url.URL{Scheme: "mem", Path: "/fstest"}
+	var err error
+
+	t.Run("Suite", func(t *testing.T) {
+		fstest.TestFileSystem(t, fs, base)
+	})
+
+	_, err = fs.StatContext(context.Background(), base)
+	if !errors.Is(err, file.ErrNotExist) {
+		t.Errorf("Stat(%s) after the tests: got error %v, expected %v",
+			base, err, file.ErrNotExist)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package mem
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/caoimhechaos/go-file"
+	"golang.org/x/net/context"
+)
+
+// A single change to a file, as queued for delivery to a watcher.
+type memChange struct {
+	path string
+	op   file.Op
+	data []byte
+}
+
+// Watcher for a file or directory in a MemFileSystem. Changes are queued
+// and delivered to the handler one at a time, in the order they happened,
+// so writers are never blocked by slow handlers.
+type MemWatcher struct {
+	ctx     context.Context
+	fs      *MemFileSystem
+	url     *url.URL
+	path    string
+	handler func(*file.Event)
+	status  *file.WatchStatus
+
+	// Whether changes anywhere below the watched directory are reported,
+	// rather than just those to the files directly in it.
+	recursive bool
+
+	mtx     sync.Mutex
+	pending []memChange
+	wakeup  chan bool
+
+	stop     chan bool
+	stopOnce sync.Once
+}
+
+// Create a new watcher for the file or directory "u" in "m". The current
+// contents of the file, or of all files in the directory, are delivered to
+// "handler" as OpCreate events before returning. If the query of "u" has
+// recursive=1, the whole tree below the directory is watched.
+func newMemWatcher(ctx context.Context, m *MemFileSystem, u *url.URL,
+	handler func(*file.Event)) *MemWatcher {
+	var recursive string = u.Query().Get("recursive")
+	var ret = &MemWatcher{
+		ctx:     ctx,
+		fs:      m,
+		url:     u,
+		path:    cleanPath(u),
+		handler: handler,
+		status:  file.NewWatchStatus(u),
+		wakeup:  make(chan bool, 1),
+		stop:    make(chan bool),
+
+		recursive: recursive == "1" || recursive == "true",
+	}
+	var initial []memChange
+	var change memChange
+	var name string
+	var f *memFile
+
+	// Take a snapshot of the current state and start listening for changes
+	// at the same time, so no change can get lost.
+	m.mtx.Lock()
+	for name, f = range m.files {
+		if ret.matches(name) {
+			initial = append(initial, memChange{
+				path: name,
+				op:   file.OpCreate,
+				data: f.data,
+			})
+		}
+	}
+	m.watchers[ret] = true
+	m.mtx.Unlock()
+
+	sort.Slice(initial, func(i, j int) bool {
+		return initial[i].path < initial[j].path
+	})
+	for _, change = range initial {
+		handler(ret.newEvent(change))
+	}
+
+	go ret.deliverChanges()
+	return ret
+}
+
+// Determine whether changes to the file "p" are of interest to the watcher,
+// i.e. whether "p" is the watched file or a file in the watched directory.
+func (w *MemWatcher) matches(p string) bool {
+	if w.recursive {
+		return p == w.path ||
+			strings.HasPrefix(p, strings.TrimSuffix(w.path, "/")+"/")
+	}
+	return p == w.path || path.Dir(p) == w.path
+}
+
+// Convert "change" into an Event. The URL of the event is the path of the
+// changed file on the scheme and host of the watched URL.
+func (w *MemWatcher) newEvent(change memChange) *file.Event {
+	var u url.URL = *w.url
+	var data []byte = change.data
+	var ev *file.Event
+
+	u.Path = change.path
+	u.RawQuery = ""
+	if change.op&(file.OpDelete|file.OpRename) != 0 {
+		ev = file.NewEvent(&u, change.op, nil, nil)
+	} else {
+		ev = file.NewEvent(&u, change.op, nil,
+			func() (io.ReadCloser, error) {
+				return file.NewReadCloserFake(
+					bytes.NewReader(data)), nil
+			})
+	}
+	ev.Name = file.RelativeName(w.path, change.path)
+	return ev
+}
+
+// Queue the operation "op" on the file "p", leaving it with the contents
+// "data", for delivery.
+func (w *MemWatcher) enqueue(p string, op file.Op, data []byte) {
+	w.mtx.Lock()
+	w.pending = append(w.pending, memChange{path: p, op: op, data: data})
+	w.mtx.Unlock()
+
+	select {
+	case w.wakeup <- true:
+	default:
+	}
+}
+
+// Deliver all queued changes to the handler until the watcher is shut
+// down, and mark the watcher as stopped then.
+func (w *MemWatcher) deliverChanges() {
+	defer w.status.Close()
+
+	for {
+		select {
+		case <-w.wakeup:
+		case <-w.stop:
+			return
+		case <-w.ctx.Done():
+			w.Shutdown()
+			return
+		}
+
+		for {
+			var change memChange
+
+			w.mtx.Lock()
+			if len(w.pending) == 0 {
+				w.mtx.Unlock()
+				break
+			}
+			change = w.pending[0]
+			w.pending = w.pending[1:]
+			w.mtx.Unlock()
+
+			select {
+			case <-w.stop:
+				return
+			default:
+			}
+
+			w.handler(w.newEvent(change))
+		}
+	}
+}
+
+// Stop listening for changes. Changes which have been queued but not yet
+// delivered are discarded.
+func (w *MemWatcher) Shutdown() error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+
+		w.fs.mtx.Lock()
+		delete(w.fs.watchers, w)
+		w.fs.mtx.Unlock()
+	})
+	return nil
+}
+
+// Retrieve the error channel associated with the watcher. Watching files
+// in memory can't fail, so no errors will ever be sent on it; it is closed
+// once the watcher has stopped.
+func (w *MemWatcher) ErrChan() chan error {
+	return w.status.ErrChan()
+}
+
+// Retrieve a channel which is closed once the watcher has stopped and
+// won't invoke its handler anymore.
+func (w *MemWatcher) Done() <-chan struct{} {
+	return w.status.Done()
+}
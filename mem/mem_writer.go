@@ -0,0 +1,107 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package mem
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+
+	"github.com/caoimhechaos/go-file"
+	"golang.org/x/net/context"
+)
+
+// Writer for files in a MemFileSystem. All data is buffered and only
+// written to the file system when the writer is closed, so readers will
+// never see partially written files.
+type MemWriter struct {
+	ctx    context.Context
+	fs     *MemFileSystem
+	op     string
+	url    *url.URL
+	path   string
+	append bool
+	opts   *file.WriteOptions
+	buf    bytes.Buffer
+	closed bool
+}
+
+// Add the bytes in "p" to the data to be written.
+func (w *MemWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, file.NewError(w.op, w.url, nil, os.ErrClosed)
+	}
+	return w.buf.Write(p)
+}
+
+// Write the data collected so far to the file, replacing or appending to
+// its previous contents. Watchers of the file will be notified. If the
+// conditions the writer was opened with don't hold, the file is left as it
+// is and an error of the kind ErrPreconditionFailed is returned.
+func (w *MemWriter) Close() error {
+	var data []byte
+	var f *memFile
+	var ok bool
+	var err error
+
+	if w.closed {
+		return file.NewError(w.op, w.url, nil, os.ErrClosed)
+	}
+	w.closed = true
+
+	if err = w.ctx.Err(); err != nil {
+		return err
+	}
+
+	w.fs.mtx.Lock()
+	defer w.fs.mtx.Unlock()
+
+	// The file system may have changed since the writer was opened.
+	if err = w.fs.checkWritable(w.op, w.url, w.path); err != nil {
+		return err
+	}
+
+	f, ok = w.fs.files[w.path]
+	if (w.opts.IfAbsent && ok) || (w.opts.IfPresent && !ok) ||
+		(w.opts.IfMatch != "" && (!ok ||
+			(&MemFileInfo{Version: f.version}).ETag() != w.opts.IfMatch)) {
+		return file.NewError(w.op, w.url, file.ErrPreconditionFailed, nil)
+	}
+
+	data = w.buf.Bytes()
+	if ok && w.append {
+		data = append(f.data[:len(f.data):len(f.data)], data...)
+	}
+
+	w.fs.store(w.path, data)
+	return nil
+}
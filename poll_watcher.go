@@ -0,0 +1,338 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// State of a file as seen by a poll, used to detect changes.
+type pollState struct {
+	size    int64
+	modTime time.Time
+	hash    []byte
+	info    os.FileInfo
+}
+
+// Determine whether the file has changed between the states "s" and "o".
+func (s *pollState) equal(o *pollState) bool {
+	return s.size == o.size && s.modTime.Equal(o.modTime) &&
+		bytes.Equal(s.hash, o.hash)
+}
+
+// Watcher detecting changes by polling a file, or the files in a directory,
+// through the regular file system operations. This is used for backends
+// which can't watch files natively.
+//
+// Files are compared by their size and modification time, as reported by
+// Stat. If the file system doesn't implement Stat or doesn't report
+// modification times, the contents of the files are read and compared by
+// their hash instead. Directories can only be watched on file systems
+// implementing Stat and List.
+type PollingWatcher struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	registry *Registry
+	url      *url.URL
+	handler  func(*Event)
+	options  *WatchOptions
+	status   *WatchStatus
+
+	// State of all files seen in the last poll, by path. Only used by
+	// the polling goroutine once the watcher has been started.
+	state map[string]*pollState
+}
+
+// Create a new watcher polling "u" through "r" and delivering an Event for
+// every change to "handler". Like the native watchers, the current state
+// is reported first, as an OpCreate event for the file or for each file in
+// the directory. The Sys field of the events holds the os.FileInfo of the
+// file if the file system implements Stat. The watcher will be shut down
+// once "ctx" is done.
+func NewPollingWatcher(ctx context.Context, r *Registry, u *url.URL,
+	handler func(*Event), opts ...WatchOption) (*PollingWatcher, error) {
+	var ret *PollingWatcher
+	var state map[string]*pollState
+	var cancel context.CancelFunc
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel = context.WithCancel(ctx)
+	ret = &PollingWatcher{
+		ctx:      ctx,
+		cancel:   cancel,
+		registry: r,
+		url:      u,
+		handler:  handler,
+		options:  NewWatchOptions(opts...),
+		status:   NewWatchStatus(u),
+		state:    make(map[string]*pollState),
+	}
+
+	state, err = ret.poll()
+	if err != nil {
+		cancel()
+		return nil, WrapError("watch", u, err)
+	}
+	ret.report(state)
+
+	go ret.run()
+	return ret, nil
+}
+
+// Get a copy of the watched URL with the path replaced by "p".
+func (w *PollingWatcher) childURL(p string) *url.URL {
+	var ret url.URL = *w.url
+
+	ret.Path = p
+	return &ret
+}
+
+// Determine the state of the file "u". "fi" is its FileInfo, if already
+// known. Returns nil if "u" is a directory.
+func (w *PollingWatcher) fingerprint(u *url.URL, fi os.FileInfo) (
+	*pollState, error) {
+	var rc io.ReadCloser
+	var hash = sha256.New()
+	var n int64
+	var err error
+
+	if fi == nil {
+		fi, err = w.registry.StatContext(w.ctx, u)
+		if errors.Is(err, ErrUnsupported) {
+			fi, err = nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if fi != nil && fi.IsDir() {
+		return nil, nil
+	}
+	if fi != nil && !fi.ModTime().IsZero() {
+		return &pollState{
+			size:    fi.Size(),
+			modTime: fi.ModTime(),
+			info:    fi,
+		}, nil
+	}
+
+	// There's no usable modification time, so compare the contents.
+	rc, err = w.registry.OpenContext(w.ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	n, err = io.Copy(hash, rc)
+	if err != nil {
+		return nil, err
+	}
+	return &pollState{
+		size: n,
+		hash: hash.Sum(nil),
+		info: fi,
+	}, nil
+}
+
+// Determine the current state of the watched file, or of all files in the
+// watched directory. A file which doesn't exist has no state.
+func (w *PollingWatcher) poll() (map[string]*pollState, error) {
+	var ret = make(map[string]*pollState)
+	var state *pollState
+	var fi os.FileInfo
+	var names []string
+	var name string
+	var err error
+
+	fi, err = w.registry.StatContext(w.ctx, w.url)
+	if err == nil && fi.IsDir() {
+		names, err = w.registry.ListContext(w.ctx, w.url)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name = range names {
+			var child *url.URL = w.childURL(path.Join(w.url.Path, name))
+
+			state, err = w.fingerprint(child, nil)
+			if errors.Is(err, ErrNotExist) {
+				// Removed since it was listed.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if state != nil {
+				ret[child.Path] = state
+			}
+		}
+		return ret, nil
+	}
+
+	if errors.Is(err, ErrUnsupported) {
+		fi, err = nil, nil
+	}
+	if errors.Is(err, ErrNotExist) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state, err = w.fingerprint(w.url, fi)
+	if errors.Is(err, ErrNotExist) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if state != nil {
+		ret[w.url.Path] = state
+	}
+	return ret, nil
+}
+
+// Report the differences between the last state seen and "state" to the
+// handler, in the order of the file names, and remember "state".
+func (w *PollingWatcher) report(state map[string]*pollState) {
+	var names []string
+	var name string
+
+	for name = range w.state {
+		if _, ok := state[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	for name = range state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name = range names {
+		var old, cur *pollState = w.state[name], state[name]
+		var u *url.URL = w.childURL(name)
+		var ev *Event
+
+		switch {
+		case old == nil:
+			ev = NewEvent(u, OpCreate, cur.info, w.opener(u))
+		case cur == nil:
+			ev = NewEvent(u, OpDelete, nil, nil)
+		case !old.equal(cur):
+			ev = NewEvent(u, OpModify, cur.info, w.opener(u))
+		default:
+			continue
+		}
+		ev.Name = RelativeName(w.url.Path, name)
+		w.handler(ev)
+	}
+
+	w.state = state
+}
+
+// Get a function opening the file "u" for an Event.
+func (w *PollingWatcher) opener(u *url.URL) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return w.registry.Open(u)
+	}
+}
+
+// Get the time to wait until the next poll.
+func (w *PollingWatcher) nextInterval() time.Duration {
+	if w.options.PollJitter <= 0 {
+		return w.options.PollInterval
+	}
+	return w.options.PollInterval +
+		time.Duration(rand.Int63n(int64(w.options.PollJitter)+1))
+}
+
+// Poll the watched URL at the configured interval until the watcher is
+// shut down, reporting changes and errors.
+func (w *PollingWatcher) run() {
+	defer w.status.Close()
+
+	for {
+		var state map[string]*pollState
+		var err error
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-time.After(w.nextInterval()):
+		}
+
+		state, err = w.poll()
+		if w.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.status.Report(err)
+			continue
+		}
+		w.report(state)
+	}
+}
+
+// Stop polling. This takes effect immediately, although a poll which is
+// already running will be aborted only if the file system honors contexts.
+func (w *PollingWatcher) Shutdown() error {
+	w.cancel()
+	return nil
+}
+
+// Retrieve the error channel associated with the watcher. It will stream
+// errors from failed polls. Errors which occur while the channel is full
+// are dropped.
+func (w *PollingWatcher) ErrChan() chan error {
+	return w.status.ErrChan()
+}
+
+// Retrieve a channel which is closed once polling has stopped.
+func (w *PollingWatcher) Done() <-chan struct{} {
+	return w.status.Done()
+}
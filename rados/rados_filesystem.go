@@ -32,23 +32,29 @@
 package rados
 
 import (
+	"errors"
 	"io"
 	"net/url"
+	"os"
+	"path"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/caoimhechaos/go-file"
 	"github.com/mrkvm/rados.go"
+	"golang.org/x/net/context"
 )
 
-// List of all currently open contexts to avoid creating them every time a file
-// is accessed.
-var openContexts map[string]*rados.Context
-var openContextsMtx sync.Mutex
-
 // radosFileSystem implements most of the important file systems on a rados
 // backend.
 type radosFileSystem struct {
 	rfs *rados.Rados
+
+	// List of all currently open contexts to avoid creating them every
+	// time a file is accessed.
+	openContexts    map[string]*rados.Context
+	openContextsMtx sync.Mutex
 }
 
 // Automatically sign us up for rados:// URLs.
@@ -56,41 +62,83 @@ func init() {
 	var rfs *rados.Rados
 	var err error
 
-	openContexts = make(map[string]*rados.Context)
-
 	rfs, err = rados.NewDefault()
 	if err == nil {
-		file.RegisterFileSystem("rados", &radosFileSystem{rfs: rfs})
+		file.RegisterFileSystem("rados", newRadosFileSystem(rfs))
+	}
+}
+
+// newRadosFileSystem creates a new rados file system using the Rados
+// client "rfs".
+func newRadosFileSystem(rfs *rados.Rados) *radosFileSystem {
+	return &radosFileSystem{
+		rfs:          rfs,
+		openContexts: make(map[string]*rados.Context),
 	}
 }
 
 // getContext returns the rados context for the specified pool, creating it if
 // necessary.
-func getContext(r *rados.Rados, pool string) (*rados.Context, error) {
+func (r *radosFileSystem) getContext(pool string) (*rados.Context, error) {
 	var ret *rados.Context
 	var ok bool
 	var err error
 
 	// TODO: this could use read/write locking to be a little more efficient.
-	openContextsMtx.Lock()
-	defer openContextsMtx.Unlock()
+	r.openContextsMtx.Lock()
+	defer r.openContextsMtx.Unlock()
 
-	ret, ok = openContexts[pool]
+	ret, ok = r.openContexts[pool]
 	if ok && ret != nil {
 		return ret, nil
 	}
 
-	ret, err = r.NewContext(pool)
+	ret, err = r.rfs.NewContext(pool)
 	if err != nil {
 		return nil, err
 	}
 
-	openContexts[pool] = ret
+	r.openContexts[pool] = ret
 	return ret, err
 }
 
+// Map the error "err" returned by librados for the operation "op" on the
+// object "u" onto the go-file error kinds. librados reports errors as
+// negative errno values, which the error kinds are derived from.
+func radosError(op string, u *url.URL, err error) error {
+	var rerr rados.RadosError
+	var kind error
+
+	if err == nil || !errors.As(err, &rerr) {
+		return file.WrapError(op, u, err)
+	}
+
+	switch syscall.Errno(-rerr) {
+	case syscall.ENOENT:
+		kind = file.ErrNotExist
+	case syscall.EEXIST:
+		kind = file.ErrExist
+	case syscall.EACCES, syscall.EPERM:
+		kind = file.ErrPermission
+	case syscall.EFBIG:
+		kind = file.ErrTooLarge
+	default:
+		return file.WrapError(op, u, err)
+	}
+
+	return file.NewError(op, u, kind, err)
+}
+
 // Register Rados client with a specific configuration file as context.
 func RegisterRadosConfig(configPath string) error {
+	return RegisterRadosConfigWithRegistry(file.DefaultRegistry, configPath)
+}
+
+// Register Rados client with a specific configuration file as context in
+// the registry "registry". This allows using different Rados clusters for
+// rados:// URLs in different registries.
+func RegisterRadosConfigWithRegistry(registry *file.Registry,
+	configPath string) error {
 	var rfs *rados.Rados
 	var err error
 
@@ -99,73 +147,110 @@ func RegisterRadosConfig(configPath string) error {
 		return err
 	}
 
-	file.RegisterFileSystem("rados", &radosFileSystem{rfs: rfs})
+	registry.RegisterFileSystem("rados", newRadosFileSystem(rfs))
 	return nil
 }
 
 // Open creates a ReadCloser for the given Rados object. The host name should be
 // the name of the Rados pool to fetch objects from.
 func (r *radosFileSystem) Open(u *url.URL) (io.ReadCloser, error) {
-	var ctx *rados.Context
+	return r.OpenContext(context.Background(), u)
+}
+
+// OpenContext creates a ReadCloser for the given Rados object, like Open.
+// Reading from the object will fail once "ctx" is done.
+func (r *radosFileSystem) OpenContext(ctx context.Context, u *url.URL) (
+	io.ReadCloser, error) {
+	var rctx *rados.Context
 	var obj *rados.Object
+	var rc *RadosReadCloser
 	var err error
 
-	ctx, err = getContext(r.rfs, u.Host)
-	if err != nil {
+	if err = ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	obj, err = ctx.Open(u.Path)
+	rctx, err = r.getContext(u.Host)
 	if err != nil {
-		return nil, err
+		return nil, radosError("open", u, err)
+	}
+
+	obj, err = rctx.Open(u.Path)
+	if err != nil {
+		return nil, radosError("open", u, err)
 	}
 
-	return NewRadosReadCloser(obj), nil
+	rc = NewRadosReadCloserContext(ctx, obj)
+	rc.url = u
+	return rc, nil
 }
 
 // OpenForWrite creates a new WriteCloser for the given Rados object. The writer
 // will truncate and append to a given Rados object.
 func (r *radosFileSystem) OpenForWrite(u *url.URL) (io.WriteCloser, error) {
-	var ctx *rados.Context
+	return r.OpenForWriteContext(context.Background(), u)
+}
+
+// OpenForWriteContext creates a new WriteCloser for the given Rados object,
+// like OpenForWrite. Writing to the object will fail once "ctx" is done.
+func (r *radosFileSystem) OpenForWriteContext(ctx context.Context,
+	u *url.URL) (io.WriteCloser, error) {
+	var rctx *rados.Context
 	var obj *rados.Object
 	var err error
 
-	ctx, err = getContext(r.rfs, u.Host)
-	if err != nil {
+	if err = ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	obj, err = ctx.Open(u.Path)
+	rctx, err = r.getContext(u.Host)
 	if err != nil {
-		return nil, err
+		return nil, radosError("write", u, err)
+	}
+
+	obj, err = rctx.Open(u.Path)
+	if err != nil {
+		return nil, radosError("write", u, err)
 	}
 
 	err = obj.Truncate(0)
 	if err != nil {
-		return nil, err
+		return nil, radosError("write", u, err)
 	}
 
-	return NewRadosWriteCloser(obj), nil
+	return NewRadosWriteCloserContext(ctx, obj), nil
 }
 
 // OpenForAppend creates a new WriteCloser for the given Rados object. Any
 // data written to this will be appended to the given Rados object.
 func (r *radosFileSystem) OpenForAppend(u *url.URL) (io.WriteCloser, error) {
-	var ctx *rados.Context
+	return r.OpenForAppendContext(context.Background(), u)
+}
+
+// OpenForAppendContext creates a new WriteCloser for appending to the given
+// Rados object, like OpenForAppend. Writing to the object will fail once
+// "ctx" is done.
+func (r *radosFileSystem) OpenForAppendContext(ctx context.Context,
+	u *url.URL) (io.WriteCloser, error) {
+	var rctx *rados.Context
 	var obj *rados.Object
 	var err error
 
-	ctx, err = getContext(r.rfs, u.Host)
-	if err != nil {
+	if err = ctx.Err(); err != nil {
 		return nil, err
 	}
 
-	obj, err = ctx.Open(u.Path)
+	rctx, err = r.getContext(u.Host)
 	if err != nil {
-		return nil, err
+		return nil, radosError("append", u, err)
 	}
 
-	return NewRadosWriteCloser(obj), nil
+	obj, err = rctx.Open(u.Path)
+	if err != nil {
+		return nil, radosError("append", u, err)
+	}
+
+	return NewRadosWriteCloserContext(ctx, obj), nil
 }
 
 // There is no List function for Rados.
@@ -174,22 +259,82 @@ func (*radosFileSystem) List(*url.URL) (r []string, err error) {
 	return
 }
 
-// There is no reasonable way to watch a file in Rados.
+// There is no List function for Rados, regardless of the context.
+func (*radosFileSystem) ListContext(context.Context, *url.URL) (
+	r []string, err error) {
+	err = file.FS_OperationNotImplementedError
+	return
+}
+
+// There is no reasonable way to watch a file in Rados. file.Watch will
+// poll the object for changes instead.
 func (*radosFileSystem) Watch(*url.URL, func(string, io.ReadCloser)) (
 	file.Watcher, error) {
 	return nil, file.FS_OperationNotImplementedError
 }
 
+// There is no reasonable way to watch a file in Rados, regardless of the
+// context.
+func (*radosFileSystem) WatchContext(context.Context, *url.URL,
+	func(string, io.ReadCloser)) (file.Watcher, error) {
+	return nil, file.FS_OperationNotImplementedError
+}
+
 // Remove the file from Rados. This will remove the named object from all
 // ceph object storage replicas.
 func (r *radosFileSystem) Remove(u *url.URL) error {
-	var ctx *rados.Context
+	return r.RemoveContext(context.Background(), u)
+}
+
+// RemoveContext removes the file from Rados like Remove, unless "ctx" is
+// already done.
+func (r *radosFileSystem) RemoveContext(ctx context.Context, u *url.URL) error {
+	var rctx *rados.Context
 	var err error
 
-	ctx, err = getContext(r.rfs, u.Host)
-	if err != nil {
+	if err = ctx.Err(); err != nil {
 		return err
 	}
 
-	return ctx.Remove(u.Path)
+	rctx, err = r.getContext(u.Host)
+	if err != nil {
+		return radosError("remove", u, err)
+	}
+
+	return radosError("remove", u, rctx.Remove(u.Path))
+}
+
+// StatContext retrieves the size of the given Rados object. Rados doesn't
+// track modification times, so the modification time is always zero. The
+// Sys() method of the result returns the *rados.Object.
+func (r *radosFileSystem) StatContext(ctx context.Context, u *url.URL) (
+	os.FileInfo, error) {
+	var rctx *rados.Context
+	var obj *rados.Object
+	var size int64
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rctx, err = r.getContext(u.Host)
+	if err != nil {
+		return nil, radosError("stat", u, err)
+	}
+
+	// Open only creates a handle, so it doesn't tell whether the object
+	// exists.
+	obj, err = rctx.Open(u.Path)
+	if err != nil {
+		return nil, radosError("stat", u, err)
+	}
+
+	size, err = obj.Stat()
+	if err != nil {
+		return nil, radosError("stat", u, err)
+	}
+
+	return file.NewFileInfo(path.Base(u.Path), size, 0644,
+		time.Time{}, obj), nil
 }
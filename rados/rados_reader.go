@@ -32,34 +32,57 @@
 package rados
 
 import (
+	"io"
+	"net/url"
 	"os"
 
 	"github.com/mrkvm/rados.go"
+	"golang.org/x/net/context"
 )
 
 // RadosReadCloser is a simple ReadCloser for Rados files. It will track its
 // current position in the Rados file and start reading from it.
 type RadosReadCloser struct {
+	ctx context.Context
 	obj *rados.Object
 	pos int64
+
+	// URL of the object, used for reporting errors, if known.
+	url *url.URL
 }
 
 // NewRadosReadCloser creates a new RadosReadCloser for the given Rados object
 // "obj".
 func NewRadosReadCloser(obj *rados.Object) *RadosReadCloser {
+	return NewRadosReadCloserContext(context.Background(), obj)
+}
+
+// NewRadosReadCloserContext creates a new RadosReadCloser for the given Rados
+// object "obj". All reads will fail once "ctx" is done.
+func NewRadosReadCloserContext(ctx context.Context,
+	obj *rados.Object) *RadosReadCloser {
 	return &RadosReadCloser{
+		ctx: ctx,
 		obj: obj,
 		pos: 0,
 	}
 }
 
 // Read fetches the next few bytes from the wrapped Rados object and puts them
-// into the buffer "p". Up to len(p) bytes will be read at a time.
+// into the buffer "p". Up to len(p) bytes will be read at a time. Since
+// opening an object doesn't check whether it exists, reading a missing
+// object reports file.ErrNotExist.
 func (r *RadosReadCloser) Read(p []byte) (n int, err error) {
+	if err = r.ctx.Err(); err != nil {
+		return
+	}
 	n, err = r.obj.ReadAt(p, r.pos)
 	if n > 0 {
 		r.pos += int64(n)
 	}
+	if err != nil && err != io.EOF {
+		err = radosError("read", r.url, err)
+	}
 	return
 }
 
@@ -34,11 +34,13 @@ package rados
 import (
 	"github.com/caoimhechaos/go-file"
 	"github.com/mrkvm/rados.go"
+	"golang.org/x/net/context"
 )
 
 // RadosWriteCloser is a simple WriteCloser for Rados files. It will append any
 // data to the wrapped Rados object, and closing won't do anything.
 type RadosWriteCloser struct {
+	ctx context.Context
 	obj *rados.Object
 	pos int64
 }
@@ -46,7 +48,15 @@ type RadosWriteCloser struct {
 // NewRadosWriteCloser creates a new RadosWriteCloser for the given Rados object
 // "obj".
 func NewRadosWriteCloser(obj *rados.Object) *RadosWriteCloser {
+	return NewRadosWriteCloserContext(context.Background(), obj)
+}
+
+// NewRadosWriteCloserContext creates a new RadosWriteCloser for the given
+// Rados object "obj". All writes will fail once "ctx" is done.
+func NewRadosWriteCloserContext(ctx context.Context,
+	obj *rados.Object) *RadosWriteCloser {
 	return &RadosWriteCloser{
+		ctx: ctx,
 		obj: obj,
 		pos: obj.Size(),
 	}
@@ -54,6 +64,9 @@ func NewRadosWriteCloser(obj *rados.Object) *RadosWriteCloser {
 
 // Write appends the bytes in "p" to the wrapped Rados object.
 func (w *RadosWriteCloser) Write(p []byte) (n int, err error) {
+	if err = w.ctx.Err(); err != nil {
+		return
+	}
 	err = w.obj.Append(p)
 	if err != nil {
 		return
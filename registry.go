@@ -0,0 +1,750 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Set of handlers for URL schemas, i.e. file system implementations and
+// watcher creators. All file operations are dispatched to the handlers
+// registered for the schema of the URL they're invoked on. Multiple
+// registries can be used in the same process, e.g. to use different etcd
+// clusters for different tenants. A Registry is safe for concurrent use.
+type Registry struct {
+	// Protects fileSystemHandlers and fileWatcherHandlers, so handlers
+	// can be registered while other goroutines are accessing files.
+	mtx sync.RWMutex
+
+	// List of URL schema handlers known.
+	fileSystemHandlers  map[string]FileSystem
+	fileWatcherHandlers map[string]WatcherCreator
+}
+
+// Registry used by the package level functions. Backends register
+// themselves here by default.
+var DefaultRegistry *Registry = NewRegistry()
+
+// Create a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		fileSystemHandlers:  make(map[string]FileSystem),
+		fileWatcherHandlers: make(map[string]WatcherCreator),
+	}
+}
+
+// Register "fs" as a file system implementation for all URLs with the given
+// "schema". Any file system previously registered for "schema" is replaced.
+func (r *Registry) RegisterFileSystem(schema string, fs FileSystem) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.fileSystemHandlers[schema] = fs
+}
+
+// Remove the file system implementation registered for "schema", if any.
+func (r *Registry) UnregisterFileSystem(schema string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.fileSystemHandlers, schema)
+}
+
+// Atomically replace both the file system implementation and the watcher
+// creator for "schema" by "fs" and "creator". Either of them may be nil, in
+// which case the respective handler is removed. There is no point in time
+// at which a mix of old and new handlers, or none at all, will be used for
+// the schema, so this can be used e.g. to rotate credentials. The handlers
+// which were registered previously are returned, so they can be cleaned up.
+func (r *Registry) RegisterScheme(schema string, fs FileSystem,
+	creator WatcherCreator) (
+	oldFs FileSystem, oldCreator WatcherCreator) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	oldFs = r.fileSystemHandlers[schema]
+	oldCreator = r.fileWatcherHandlers[schema]
+
+	if fs == nil {
+		delete(r.fileSystemHandlers, schema)
+	} else {
+		r.fileSystemHandlers[schema] = fs
+	}
+
+	if creator == nil {
+		delete(r.fileWatcherHandlers, schema)
+	} else {
+		r.fileWatcherHandlers[schema] = creator
+	}
+	return
+}
+
+// Get a sorted list of all schemas for which either a file system or a
+// watcher has been registered.
+func (r *Registry) RegisteredSchemes() []string {
+	var schemas map[string]bool = make(map[string]bool)
+	var ret []string
+	var schema string
+
+	r.mtx.RLock()
+	for schema = range r.fileSystemHandlers {
+		schemas[schema] = true
+	}
+	for schema = range r.fileWatcherHandlers {
+		schemas[schema] = true
+	}
+	r.mtx.RUnlock()
+
+	for schema = range schemas {
+		ret = append(ret, schema)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Look up the file system implementation registered for "schema".
+func (r *Registry) lookupFileSystem(schema string) (
+	fs FileSystem, ok bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	fs, ok = r.fileSystemHandlers[schema]
+	return
+}
+
+// Register "creator" as a handler for watchers for all URLs with the given
+// "schema". Any watcher creator previously registered for "schema" is
+// replaced.
+func (r *Registry) RegisterWatcher(schema string, creator WatcherCreator) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.fileWatcherHandlers[schema] = creator
+}
+
+// Remove the watcher creator registered for "schema", if any.
+func (r *Registry) UnregisterWatcher(schema string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.fileWatcherHandlers, schema)
+}
+
+// Look up the watcher creator registered for "schema".
+func (r *Registry) lookupWatcher(schema string) (
+	creator WatcherCreator, ok bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	creator, ok = r.fileWatcherHandlers[schema]
+	return
+}
+
+// Watch the given "fileurl" for changes, sending all of them to the specified
+// "handler". This will look up the required handler for the scheme specified
+// in the URL and forward the watch request. A DoneWatcher is returned
+// which can be used to stop watching, as defined by the individual watchers.
+// If the file system can't watch files natively, it is polled for changes.
+func (r *Registry) Watch(fileurl *url.URL,
+	handler func(string, io.ReadCloser), opts ...WatchOption) (
+	DoneWatcher, error) {
+	return r.WatchContext(context.Background(), fileurl, handler, opts...)
+}
+
+// Like Watch, but the watcher will also be shut down once "ctx" is done.
+func (r *Registry) WatchContext(ctx context.Context, fileurl *url.URL,
+	handler func(string, io.ReadCloser), opts ...WatchOption) (
+	DoneWatcher, error) {
+	return r.WatchEventsContext(ctx, fileurl, CallbackEventHandler(handler),
+		opts...)
+}
+
+// Watch the given "fileurl" for changes, sending an Event for each of them
+// to "handler". This works like Watch, but handlers can tell apart the
+// different kinds of changes.
+func (r *Registry) WatchEvents(fileurl *url.URL, handler func(*Event),
+	opts ...WatchOption) (DoneWatcher, error) {
+	return r.WatchEventsContext(context.Background(), fileurl, handler,
+		opts...)
+}
+
+// Like WatchEvents, but the watcher will also be shut down once "ctx" is
+// done. Backends which can't produce Events themselves are watched through
+// their regular callbacks, and all changes are reported as OpModify events.
+// Backends which can't watch files at all are polled for changes by a
+// PollingWatcher, as are all backends if WithPolling is given. If any of
+// WithSerialize, WithCoalesce or WithDebounce is given, events are passed
+// to "handler" through an EventQueue.
+//
+// If WithGlob is given and the path of "fileurl" is a glob pattern, as
+// described for MatchPattern, the directory containing all of its matches
+// is watched instead, and only events for files matching the pattern are
+// delivered.
+func (r *Registry) WatchEventsContext(ctx context.Context, fileurl *url.URL,
+	handler func(*Event), opts ...WatchOption) (DoneWatcher, error) {
+	var options *WatchOptions = NewWatchOptions(opts...)
+	var queue *EventQueue
+	var watcher DoneWatcher
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if options.Glob && IsPattern(fileurl.Path) {
+		fileurl, handler, err = globWatch(fileurl, handler)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !options.queued() {
+		return r.watchEvents(ctx, fileurl, handler, options, opts)
+	}
+
+	queue = NewEventQueue(ctx, handler, opts...)
+	watcher, err = r.watchEvents(ctx, fileurl, queue.Push, options, opts)
+	if err != nil {
+		queue.Close()
+		return nil, err
+	}
+	return newQueuedWatcher(watcher, queue), nil
+}
+
+// Watch "fileurl" natively if possible, or by polling otherwise, sending an
+// Event for each change to "handler".
+func (r *Registry) watchEvents(ctx context.Context, fileurl *url.URL,
+	handler func(*Event), options *WatchOptions, opts []WatchOption) (
+	DoneWatcher, error) {
+	var poller *PollingWatcher
+	var watcher DoneWatcher
+	var ok bool
+	var err error
+
+	if !options.Poll {
+		watcher, err = r.watchNative(ctx, fileurl, handler)
+		if !errors.Is(err, FS_OperationNotImplementedError) {
+			return watcher, err
+		}
+	}
+
+	// Polling requires a file system to read the files from.
+	if _, ok = r.lookupFileSystem(fileurl.Scheme); !ok {
+		return nil, FS_OperationNotImplementedError
+	}
+
+	poller, err = NewPollingWatcher(ctx, r, fileurl, handler, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return poller, nil
+}
+
+// Watch "fileurl" using the watching capabilities of its backend, sending
+// an Event for each change to "handler". The file system implementation is
+// preferred over a separately registered watcher creator. Watchers which
+// don't implement DoneWatcher are wrapped into one.
+func (r *Registry) watchNative(ctx context.Context, fileurl *url.URL,
+	handler func(*Event)) (DoneWatcher, error) {
+	var creator WatcherCreator
+	var fs FileSystem
+	var watcher Watcher
+	var ok bool
+	var err error
+
+	fs, ok = r.lookupFileSystem(fileurl.Scheme)
+	if ok {
+		var efs EventWatcherCreator
+		var cfs ContextFileSystem
+
+		if efs, ok = fs.(EventWatcherCreator); ok {
+			return doneWatcher(efs.WatchEventsContext(ctx, fileurl,
+				handler))
+		}
+
+		if cfs, ok = fs.(ContextFileSystem); ok {
+			return doneWatcher(cfs.WatchContext(ctx, fileurl,
+				eventCallback(fileurl, handler)))
+		}
+
+		watcher, err = fs.Watch(fileurl, eventCallback(fileurl, handler))
+		if err != nil {
+			return nil, err
+		}
+		return shutdownOnDone(ctx, watcher), nil
+	}
+
+	// Otherwise, try to find a simple watcher implementation.
+	creator, ok = r.lookupWatcher(fileurl.Scheme)
+	if ok {
+		var ecreator EventWatcherCreator
+		var ccreator ContextWatcherCreator
+
+		if ecreator, ok = creator.(EventWatcherCreator); ok {
+			return doneWatcher(ecreator.WatchEventsContext(ctx,
+				fileurl, handler))
+		}
+
+		if ccreator, ok = creator.(ContextWatcherCreator); ok {
+			return doneWatcher(ccreator.WatchContext(ctx, fileurl,
+				eventCallback(fileurl, handler)))
+		}
+
+		watcher, err = creator.Watch(fileurl,
+			eventCallback(fileurl, handler))
+		if err != nil {
+			return nil, err
+		}
+		return shutdownOnDone(ctx, watcher), nil
+	}
+
+	return nil, FS_OperationNotImplementedError
+}
+
+// Watch the given "fileurl" for changes, delivering an Event for each of
+// them on the channel returned. Errors, including failure to set up the
+// watch, are delivered in-band as events with Err set. Watching continues
+// until "ctx" is done, at which point the channel is closed; callers must
+// make sure "ctx" is eventually cancelled to release the watcher. If the
+// watch can't be set up, the channel is closed after delivering the error.
+func (r *Registry) WatchChan(ctx context.Context, fileurl *url.URL,
+	opts ...WatchOption) <-chan *Event {
+	var c *eventChan = &eventChan{
+		ctx: ctx,
+		ch:  make(chan *Event),
+	}
+
+	go func() {
+		var watcher Watcher
+		var err error
+
+		// Initial state may be delivered before WatchEventsContext
+		// returns, so this can't be done before returning the channel.
+		watcher, err = r.WatchEventsContext(ctx, fileurl, c.send, opts...)
+		if err != nil {
+			c.send(&Event{URL: fileurl, Err: err})
+			c.close()
+			return
+		}
+
+		// The watcher shuts itself down once "ctx" is done.
+		go c.forwardErrors(fileurl, watcher.ErrChan())
+
+		<-ctx.Done()
+		c.close()
+	}()
+
+	return c.ch
+}
+
+// Read all names under the given path as file names. Requires "u" to point
+// to a directory. The list of file names returned should only be short,
+// local names which can be appended to the URL to form a new one.
+func (r *Registry) List(u *url.URL) ([]string, error) {
+	return r.ListContext(context.Background(), u)
+}
+
+// Like List, but the operation will be aborted once "ctx" is done.
+func (r *Registry) ListContext(ctx context.Context, u *url.URL) (
+	[]string, error) {
+	var fs FileSystem
+	var cfs ContextFileSystem
+	var ok bool
+	var err error
+
+	fs, ok = r.lookupFileSystem(u.Scheme)
+	if !ok {
+		return nil, FS_OperationNotImplementedError
+	}
+
+	if cfs, ok = fs.(ContextFileSystem); ok {
+		return cfs.ListContext(ctx, u)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fs.List(u)
+}
+
+// Find all files matching the glob pattern "pattern", as described for
+// MatchPattern. The scheme, host and query of the pattern are kept for the
+// URLs returned, which are sorted.
+func (r *Registry) Glob(pattern *url.URL) ([]*url.URL, error) {
+	return r.GlobContext(context.Background(), pattern)
+}
+
+// Like Glob, but the operation will be aborted once "ctx" is done. File
+// systems implementing GlobFileSystem expand the pattern themselves; on
+// all others, the directories matches may be in are listed.
+func (r *Registry) GlobContext(ctx context.Context, pattern *url.URL) (
+	[]*url.URL, error) {
+	var fs FileSystem
+	var gfs GlobFileSystem
+	var found map[string]*url.URL = make(map[string]*url.URL)
+	var dir url.URL = *pattern
+	var rest []string
+	var ret []*url.URL
+	var u *url.URL
+	var ok bool
+	var err error
+
+	fs, ok = r.lookupFileSystem(pattern.Scheme)
+	if !ok {
+		return nil, FS_OperationNotImplementedError
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err = checkPattern("glob", pattern); err != nil {
+		return nil, err
+	}
+
+	if gfs, ok = fs.(GlobFileSystem); ok {
+		ret, err = gfs.GlobContext(ctx, pattern)
+		if !errors.Is(err, FS_OperationNotImplementedError) {
+			return ret, err
+		}
+	}
+
+	dir.Path, rest = patternBase(pattern.Path)
+	if err = r.globList(ctx, &dir, rest, found, true); err != nil {
+		return nil, err
+	}
+
+	for _, u = range found {
+		ret = append(ret, u)
+	}
+	sortURLs(ret)
+	return ret, nil
+}
+
+// Return a reader for the file given as "u".
+func (r *Registry) Open(u *url.URL) (io.ReadCloser, error) {
+	return r.OpenContext(context.Background(), u)
+}
+
+// Like Open, but the operation will be aborted once "ctx" is done. On file
+// systems supporting it, "ctx" will also apply to reading from the file.
+func (r *Registry) OpenContext(ctx context.Context, u *url.URL) (
+	io.ReadCloser, error) {
+	var fs FileSystem
+	var cfs ContextFileSystem
+	var ok bool
+	var err error
+
+	fs, ok = r.lookupFileSystem(u.Scheme)
+	if !ok {
+		return nil, FS_OperationNotImplementedError
+	}
+
+	if cfs, ok = fs.(ContextFileSystem); ok {
+		return cfs.OpenContext(ctx, u)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fs.Open(u)
+}
+
+// Return a writer for the file given as "u". Any writer should
+// guarantee that all data has been written by the time Close()
+// returns without an error. No other guarantees have to be given.
+// The file will only be written if the conditions in "opts" hold.
+func (r *Registry) OpenForWrite(u *url.URL, opts ...WriteOption) (
+	io.WriteCloser, error) {
+	return r.OpenForWriteContext(context.Background(), u, opts...)
+}
+
+// Like OpenForWrite, but the operation will be aborted once "ctx" is done.
+// On file systems supporting it, "ctx" will also apply to writing to the
+// file, up to and including Close().
+func (r *Registry) OpenForWriteContext(ctx context.Context, u *url.URL,
+	opts ...WriteOption) (io.WriteCloser, error) {
+	var options *WriteOptions = NewWriteOptions(opts...)
+	var fs FileSystem
+	var cfs ContextFileSystem
+	var wfs WriteOptionsFileSystem
+	var ok bool
+	var err error
+
+	fs, ok = r.lookupFileSystem(u.Scheme)
+	if !ok {
+		return nil, FS_OperationNotImplementedError
+	}
+
+	if options.contradictory() {
+		return nil, NewError("write", u, ErrPreconditionFailed, nil)
+	}
+	if wfs, ok = fs.(WriteOptionsFileSystem); ok {
+		return wfs.OpenForWriteOptionsContext(ctx, u, options)
+	}
+	if options.Conditional() || options.Atomic || options.TTL > 0 {
+		return nil, FS_OperationNotImplementedError
+	}
+
+	if cfs, ok = fs.(ContextFileSystem); ok {
+		return cfs.OpenForWriteContext(ctx, u)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fs.OpenForWrite(u)
+}
+
+// Return a writer for appending data to the file given as "u". Any
+// writer should guarantee that all data has been written by the time
+// Close() returns without an error. No other guarantees have to be
+// given.
+func (r *Registry) OpenForAppend(u *url.URL) (io.WriteCloser, error) {
+	return r.OpenForAppendContext(context.Background(), u)
+}
+
+// Like OpenForAppend, but the operation will be aborted once "ctx" is done.
+// On file systems supporting it, "ctx" will also apply to writing to the
+// file, up to and including Close().
+func (r *Registry) OpenForAppendContext(ctx context.Context, u *url.URL) (
+	io.WriteCloser, error) {
+	var fs FileSystem
+	var cfs ContextFileSystem
+	var ok bool
+	var err error
+
+	fs, ok = r.lookupFileSystem(u.Scheme)
+	if !ok {
+		return nil, FS_OperationNotImplementedError
+	}
+
+	if cfs, ok = fs.(ContextFileSystem); ok {
+		return cfs.OpenForAppendContext(ctx, u)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	return fs.OpenForAppend(u)
+}
+
+// Remove the referenced object from the file system. This would cause
+// the file to be deleted from the underlying file system, or whatever
+// operation is equivalent to that.
+func (r *Registry) Remove(u *url.URL) error {
+	return r.RemoveContext(context.Background(), u)
+}
+
+// Like Remove, but the operation will be aborted once "ctx" is done.
+func (r *Registry) RemoveContext(ctx context.Context, u *url.URL) error {
+	var fs FileSystem
+	var cfs ContextFileSystem
+	var ok bool
+	var err error
+
+	fs, ok = r.lookupFileSystem(u.Scheme)
+	if !ok {
+		return FS_OperationNotImplementedError
+	}
+
+	if cfs, ok = fs.(ContextFileSystem); ok {
+		return cfs.RemoveContext(ctx, u)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	return fs.Remove(u)
+}
+
+// Retrieve metadata about the file given as "u", such as its size and
+// modification time, without opening it.
+func (r *Registry) Stat(u *url.URL) (os.FileInfo, error) {
+	return r.StatContext(context.Background(), u)
+}
+
+// Like Stat, but the operation will be aborted once "ctx" is done.
+func (r *Registry) StatContext(ctx context.Context, u *url.URL) (
+	os.FileInfo, error) {
+	var fs FileSystem
+	var sfs StatFileSystem
+	var ok bool
+
+	fs, ok = r.lookupFileSystem(u.Scheme)
+	if !ok {
+		return nil, FS_OperationNotImplementedError
+	}
+
+	if sfs, ok = fs.(StatFileSystem); ok {
+		return sfs.StatContext(ctx, u)
+	}
+
+	return nil, FS_OperationNotImplementedError
+}
+
+// Move the file "src" to "dst". If both are on the same file system and it
+// supports renaming files natively, that will be used. Otherwise, the
+// contents will be copied to "dst" and "src" will be removed afterwards.
+// "atomic" reports whether the file was renamed atomically; this is never
+// the case when falling back to copying. Renaming a file to itself leaves
+// it as it is.
+func (r *Registry) Rename(src, dst *url.URL) (atomic bool, err error) {
+	return r.RenameContext(context.Background(), src, dst)
+}
+
+// Like Rename, but the operation will be aborted once "ctx" is done.
+func (r *Registry) RenameContext(ctx context.Context,
+	src, dst *url.URL) (
+	atomic bool, err error) {
+	var fs FileSystem
+	var rfs RenameFileSystem
+	var ok bool
+
+	if src.Scheme == dst.Scheme {
+		fs, ok = r.lookupFileSystem(src.Scheme)
+		if !ok {
+			return false, FS_OperationNotImplementedError
+		}
+
+		if rfs, ok = fs.(RenameFileSystem); ok {
+			err = rfs.RenameContext(ctx, src, dst)
+			if !errors.Is(err, FS_OperationNotImplementedError) {
+				return err == nil, err
+			}
+		}
+	}
+
+	if sameFile(src, dst) {
+		err = r.checkExists(ctx, src)
+		return err == nil, err
+	}
+
+	err = r.copyContents(ctx, src, dst)
+	if err != nil {
+		return false, err
+	}
+
+	return false, r.RemoveContext(ctx, src)
+}
+
+// Copy the contents of the file "src" to "dst". If both are on the same
+// file system and it supports copying files natively, that will be used.
+// Otherwise, the contents will be streamed from "src" to "dst". "atomic"
+// reports whether the file was copied atomically; this is never the case
+// when falling back to streaming. Copying a file onto itself leaves it as
+// it is.
+func (r *Registry) Copy(src, dst *url.URL) (atomic bool, err error) {
+	return r.CopyContext(context.Background(), src, dst)
+}
+
+// Like Copy, but the operation will be aborted once "ctx" is done.
+func (r *Registry) CopyContext(ctx context.Context,
+	src, dst *url.URL) (
+	atomic bool, err error) {
+	var fs FileSystem
+	var cfs CopyFileSystem
+	var ok bool
+
+	if src.Scheme == dst.Scheme {
+		fs, ok = r.lookupFileSystem(src.Scheme)
+		if !ok {
+			return false, FS_OperationNotImplementedError
+		}
+
+		if cfs, ok = fs.(CopyFileSystem); ok {
+			err = cfs.CopyContext(ctx, src, dst)
+			if !errors.Is(err, FS_OperationNotImplementedError) {
+				return err == nil, err
+			}
+		}
+	}
+
+	if sameFile(src, dst) {
+		err = r.checkExists(ctx, src)
+		return err == nil, err
+	}
+
+	return false, r.copyContents(ctx, src, dst)
+}
+
+// Determine whether "src" and "dst" refer to the same file. Copying such a
+// file onto itself by streaming would truncate it before it is read.
+func sameFile(src, dst *url.URL) bool {
+	return src.Scheme == dst.Scheme && src.Host == dst.Host &&
+		path.Clean("/"+src.Path) == path.Clean("/"+dst.Path)
+}
+
+// Make sure the file "u" exists, for operations which leave it as it is.
+func (r *Registry) checkExists(ctx context.Context, u *url.URL) error {
+	var rc io.ReadCloser
+	var err error
+
+	if rc, err = r.OpenContext(ctx, u); err != nil {
+		return err
+	}
+	return rc.Close()
+}
+
+// Stream the contents of "src" into "dst", which will be truncated first.
+func (r *Registry) copyContents(ctx context.Context,
+	src, dst *url.URL) error {
+	var rc io.ReadCloser
+	var wc io.WriteCloser
+	var err error
+
+	rc, err = r.OpenContext(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	wc, err = r.OpenForWriteContext(ctx, dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(wc, rc)
+	if err != nil {
+		wc.Close()
+		return err
+	}
+
+	return wc.Close()
+}
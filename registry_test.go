@@ -0,0 +1,151 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caoimhechaos/go-file"
+	filefs "github.com/caoimhechaos/go-file/file"
+	"golang.org/x/net/context"
+)
+
+// Copying or renaming a file onto itself must not lose its contents, even
+// if the file system has to fall back to streaming.
+func TestCopyOntoItself(t *testing.T) {
+	var registry *file.Registry = file.NewRegistry()
+	var dir string = t.TempDir()
+	var name string = filepath.Join(dir, "file")
+	var u *url.URL = &url.URL{Scheme: "file", Path: name}
+	var alias *url.URL = &url.URL{Scheme: "file", Path: dir + "/./file"}
+	var data []byte
+	var err error
+
+	registry.RegisterFileSystem("file", &filefs.FileFileSystemIntegration{})
+
+	if err = ioutil.WriteFile(name, []byte("data"), 0644); err != nil {
+		t.Fatalf("Writing %s: %s", name, err)
+	}
+
+	if _, err = registry.Copy(u, u); err != nil {
+		t.Errorf("Copy(%s, %s): %s", u, u, err)
+	}
+	if _, err = registry.Copy(u, alias); err != nil {
+		t.Errorf("Copy(%s, %s): %s", u, alias, err)
+	}
+	if _, err = registry.Rename(u, alias); err != nil {
+		t.Errorf("Rename(%s, %s): %s", u, alias, err)
+	}
+
+	if data, err = ioutil.ReadFile(name); err != nil {
+		t.Fatalf("Reading %s: %s", name, err)
+	}
+	if !bytes.Equal(data, []byte("data")) {
+		t.Errorf("Contents of %s: got %q, expected %q", name, data, "data")
+	}
+
+	os.Remove(name)
+	if _, err = registry.Copy(u, u); err == nil {
+		t.Errorf("Copy(%s, %s) of a missing file succeeded", u, u)
+	}
+}
+
+// Watcher creator whose watchers don't implement file.DoneWatcher.
+type legacyCreator struct{}
+
+// Watcher created by legacyCreator.
+type legacyWatcher struct {
+	errchan chan error
+}
+
+// Create a watcher which never reports any changes.
+func (legacyCreator) Watch(u *url.URL, cb func(string, io.ReadCloser)) (
+	file.Watcher, error) {
+	return &legacyWatcher{errchan: make(chan error)}, nil
+}
+
+// Stop the watcher.
+func (w *legacyWatcher) Shutdown() error {
+	return nil
+}
+
+// Retrieve the error channel of the watcher.
+func (w *legacyWatcher) ErrChan() chan error {
+	return w.errchan
+}
+
+// Watchers which don't implement file.DoneWatcher should be done once
+// they have been shut down, either directly or through the context.
+func TestWatchAdaptsLegacyWatchers(t *testing.T) {
+	var registry *file.Registry = file.NewRegistry()
+	var u *url.URL = &url.URL{Scheme: "legacy", Path: "/file"}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var watcher file.DoneWatcher
+	var err error
+
+	registry.RegisterWatcher("legacy", legacyCreator{})
+
+	if watcher, err = registry.Watch(u, nil); err != nil {
+		t.Fatalf("Watch(%s): %s", u, err)
+	}
+	select {
+	case <-watcher.Done():
+		t.Errorf("Watcher for %s done before Shutdown()", u)
+	default:
+	}
+	watcher.Shutdown()
+	select {
+	case <-watcher.Done():
+	default:
+		t.Errorf("Watcher for %s not done after Shutdown()", u)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	if watcher, err = registry.WatchContext(ctx, u, nil); err != nil {
+		t.Fatalf("WatchContext(%s): %s", u, err)
+	}
+	cancel()
+	select {
+	case <-watcher.Done():
+	case <-time.After(10 * time.Second):
+		t.Errorf("Watcher for %s not done after cancelling its context",
+			u)
+	}
+}
@@ -0,0 +1,336 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Multiplexer sharing a single underlying watch per URL between any number
+// of subscribers, to keep the number of inotify instances, etcd watch
+// streams and the like down. Each subscriber receives all events for the
+// URL through its own EventQueue, so a slow subscriber doesn't hold up the
+// others. The underlying watch is created with the first subscription and
+// shut down once the last subscriber has left. If the underlying watch
+// stops by itself, all subscriptions to it end as well.
+type WatchHub struct {
+	registry *Registry
+	options  []WatchOption
+
+	mtx     sync.Mutex
+	watches map[string]*hubWatch
+}
+
+// Hub sharing watches created through the DefaultRegistry.
+var DefaultWatchHub = NewWatchHub(DefaultRegistry)
+
+// Create a new WatchHub creating the underlying watches through "r", with
+// the options "opts". Options controlling the delivery of events, such as
+// WithDebounce, apply to the underlying watch and thus to all subscribers.
+func NewWatchHub(r *Registry, opts ...WatchOption) *WatchHub {
+	return &WatchHub{
+		registry: r,
+		options:  opts,
+		watches:  make(map[string]*hubWatch),
+	}
+}
+
+// An underlying watch shared by all subscribers of a URL.
+type hubWatch struct {
+	hub     *WatchHub
+	key     string
+	url     *url.URL
+	watcher Watcher
+	err     error
+	refs    int
+	ready   chan bool
+
+	// Protects "subs" and "state". Events are dispatched with the lock
+	// held, so new subscribers get a consistent view of the state.
+	mtx   sync.Mutex
+	subs  map[*HubSubscription]bool
+	state map[string]*Event
+}
+
+// A single subscriber's view of a watch shared through a WatchHub. It
+// implements DoneWatcher; shutting it down ends the subscription.
+type HubSubscription struct {
+	watch    *hubWatch
+	queue    *EventQueue
+	status   *WatchStatus
+	stop     chan bool
+	stopOnce sync.Once
+}
+
+// Subscribe to changes of "fileurl", like Watch.
+func (h *WatchHub) Watch(fileurl *url.URL,
+	handler func(string, io.ReadCloser)) (Watcher, error) {
+	return h.WatchEventsContext(context.Background(), fileurl,
+		CallbackEventHandler(handler))
+}
+
+// Subscribe to changes of "fileurl", like WatchContext.
+func (h *WatchHub) WatchContext(ctx context.Context, fileurl *url.URL,
+	handler func(string, io.ReadCloser)) (Watcher, error) {
+	return h.WatchEventsContext(ctx, fileurl, CallbackEventHandler(handler))
+}
+
+// Subscribe to changes of "fileurl", like WatchEvents.
+func (h *WatchHub) WatchEvents(fileurl *url.URL, handler func(*Event)) (
+	Watcher, error) {
+	return h.WatchEventsContext(context.Background(), fileurl, handler)
+}
+
+// Subscribe to changes of "fileurl", delivering an Event for each of them
+// to "handler" until the subscription is shut down or "ctx" is done. If
+// there already is a watch for "fileurl", it is shared; the current state
+// of the files seen by it is delivered to "handler" first, as OpCreate
+// events, just like a new watch would.
+func (h *WatchHub) WatchEventsContext(ctx context.Context, fileurl *url.URL,
+	handler func(*Event)) (Watcher, error) {
+	var key string = fileurl.String()
+	var hw *hubWatch
+	var sub *HubSubscription
+	var ok bool
+	var err error
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	h.mtx.Lock()
+	hw, ok = h.watches[key]
+	if !ok {
+		hw = &hubWatch{
+			hub:   h,
+			key:   key,
+			url:   fileurl,
+			ready: make(chan bool),
+			subs:  make(map[*HubSubscription]bool),
+			state: make(map[string]*Event),
+		}
+		h.watches[key] = hw
+	}
+	hw.refs++
+	h.mtx.Unlock()
+
+	if !ok {
+		hw.start()
+	}
+	<-hw.ready
+
+	if hw.err != nil {
+		h.release(hw)
+		return nil, hw.err
+	}
+
+	sub = &HubSubscription{
+		watch:  hw,
+		queue:  NewEventQueue(ctx, handler),
+		status: NewWatchStatus(fileurl),
+		stop:   make(chan bool),
+	}
+	hw.add(sub)
+
+	go func() {
+		<-sub.queue.Done()
+		sub.status.Close()
+	}()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				sub.Shutdown()
+			case <-sub.stop:
+			}
+		}()
+	}
+	return sub, nil
+}
+
+// Get the number of underlying watches currently held by the hub.
+func (h *WatchHub) NumWatches() int {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	return len(h.watches)
+}
+
+// Drop a reference to "hw", shutting it down if it was the last one.
+func (h *WatchHub) release(hw *hubWatch) {
+	h.mtx.Lock()
+	hw.refs--
+	if hw.refs > 0 {
+		h.mtx.Unlock()
+		return
+	}
+	if h.watches[hw.key] == hw {
+		delete(h.watches, hw.key)
+	}
+	h.mtx.Unlock()
+
+	if hw.watcher != nil {
+		hw.watcher.Shutdown()
+	}
+}
+
+// Create the underlying watch and mark it as ready. If this fails, the
+// error is kept for all subscribers waiting for it.
+func (hw *hubWatch) start() {
+	defer close(hw.ready)
+
+	// The watch is shared, so it must not be bound to the context of
+	// any individual subscriber.
+	hw.watcher, hw.err = hw.hub.registry.WatchEventsContext(
+		context.Background(), hw.url, hw.dispatch, hw.hub.options...)
+	if hw.err != nil {
+		hw.watcher = nil
+		return
+	}
+
+	go hw.forwardErrors()
+}
+
+// Record the state change reported by "ev" and pass it on to all
+// subscribers.
+func (hw *hubWatch) dispatch(ev *Event) {
+	var sub *HubSubscription
+	var key string = ev.URL.String()
+
+	hw.mtx.Lock()
+	defer hw.mtx.Unlock()
+
+	if ev.Op&(OpDelete|OpRename) != 0 {
+		delete(hw.state, key)
+	} else {
+		hw.state[key] = ev
+	}
+
+	for sub = range hw.subs {
+		sub.queue.Push(ev)
+	}
+}
+
+// Add the subscriber "sub", delivering the current state to it first.
+func (hw *hubWatch) add(sub *HubSubscription) {
+	var keys []string
+	var key string
+
+	hw.mtx.Lock()
+	defer hw.mtx.Unlock()
+
+	for key = range hw.state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key = range keys {
+		var ev Event = *hw.state[key]
+
+		ev.Op = OpCreate
+		sub.queue.Push(&ev)
+	}
+
+	hw.subs[sub] = true
+}
+
+// Remove the subscriber "sub".
+func (hw *hubWatch) remove(sub *HubSubscription) {
+	hw.mtx.Lock()
+	delete(hw.subs, sub)
+	hw.mtx.Unlock()
+}
+
+// Pass all errors of the underlying watcher on to the subscribers until
+// the watcher has stopped. If it stopped by itself rather than because the
+// last subscriber left, the remaining subscriptions are ended, and the next
+// subscriber will get a new watch.
+func (hw *hubWatch) forwardErrors() {
+	var subs []*HubSubscription
+	var sub *HubSubscription
+	var err error
+
+	for err = range hw.watcher.ErrChan() {
+		hw.mtx.Lock()
+		for sub = range hw.subs {
+			sub.status.Report(err)
+		}
+		hw.mtx.Unlock()
+	}
+
+	hw.hub.mtx.Lock()
+	if hw.hub.watches[hw.key] == hw {
+		delete(hw.hub.watches, hw.key)
+	}
+	hw.hub.mtx.Unlock()
+
+	hw.mtx.Lock()
+	for sub = range hw.subs {
+		subs = append(subs, sub)
+	}
+	hw.mtx.Unlock()
+
+	for _, sub = range subs {
+		sub.Shutdown()
+	}
+}
+
+// End the subscription. The underlying watch is shut down if this was the
+// last subscription to it. It is safe to call this more than once.
+func (s *HubSubscription) Shutdown() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		s.queue.Close()
+		s.watch.remove(s)
+		s.watch.hub.release(s.watch)
+	})
+	return nil
+}
+
+// Retrieve the error channel of the subscription. It receives all errors
+// reported by the underlying watcher; errors which occur while it is full
+// are dropped.
+func (s *HubSubscription) ErrChan() chan error {
+	return s.status.ErrChan()
+}
+
+// Retrieve a channel which is closed once the subscription has ended and
+// its handler won't be invoked anymore.
+func (s *HubSubscription) Done() <-chan struct{} {
+	return s.status.Done()
+}
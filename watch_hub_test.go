@@ -0,0 +1,125 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Watcher creator which only supports callbacks, reporting a single change
+// to the file being watched.
+type callbackCreator struct{}
+
+// Watcher created by callbackCreator.
+type callbackWatcher struct {
+	*WatchStatus
+}
+
+// Report the contents "v1" for the file "u" once the watch has started.
+func (callbackCreator) Watch(u *url.URL, cb func(string, io.ReadCloser)) (
+	Watcher, error) {
+	go cb(u.Path, NewReadCloserFake(bytes.NewReader([]byte("v1"))))
+	return &callbackWatcher{NewWatchStatus(u)}, nil
+}
+
+// Stop the watcher.
+func (w *callbackWatcher) Shutdown() error {
+	w.Close()
+	return nil
+}
+
+// Subscribe to "u" on "hub" and return the contents of the first event.
+func readFirstEvent(t *testing.T, hub *WatchHub, u *url.URL) string {
+	var contents chan string = make(chan string, 1)
+	var sub Watcher
+	var err error
+
+	t.Helper()
+
+	sub, err = hub.WatchEvents(u, func(ev *Event) {
+		var rc io.ReadCloser
+		var data []byte
+		var err error
+
+		if rc, err = ev.Open(); err == nil {
+			data, _ = ioutil.ReadAll(rc)
+			rc.Close()
+		}
+		select {
+		case contents <- string(data):
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatalf("Watching %s: %s", u, err)
+	}
+	defer sub.Shutdown()
+
+	select {
+	case <-time.After(10 * time.Second):
+		t.Fatalf("No event for %s", u)
+	case data := <-contents:
+		return data
+	}
+	return ""
+}
+
+// All subscribers of a shared watch should be able to read the contents of
+// a file, even if the backend hands them out only once.
+func TestWatchHubSharesContents(t *testing.T) {
+	var registry *Registry = NewRegistry()
+	var hub *WatchHub = NewWatchHub(registry)
+	var u *url.URL = &url.URL{Scheme: "cb", Path: "/file"}
+	var first Watcher
+	var data string
+	var err error
+
+	registry.RegisterWatcher("cb", callbackCreator{})
+
+	// Keep the watch alive between the other subscriptions.
+	first, err = hub.WatchEvents(u, func(*Event) {})
+	if err != nil {
+		t.Fatalf("Watching %s: %s", u, err)
+	}
+	defer first.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		if data = readFirstEvent(t, hub, u); data != "v1" {
+			t.Errorf("Subscriber %d read %q, expected %q", i, data, "v1")
+		}
+	}
+}
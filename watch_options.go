@@ -0,0 +1,165 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"time"
+)
+
+// Default interval between two polls of a file being watched by polling.
+const DefaultPollInterval = 10 * time.Second
+
+// Options affecting how files are watched. They are set through the
+// WatchOption functions passed to Watch, WatchEvents or WatchChan.
+type WatchOptions struct {
+	// Watch the file by polling it even if the backend can watch it
+	// natively.
+	Poll bool
+
+	// Interval between two polls when watching by polling. Intervals of
+	// zero or less are replaced by DefaultPollInterval.
+	PollInterval time.Duration
+
+	// Maximum random delay added to each poll interval, so watchers
+	// started at the same time don't all poll at the same time. By
+	// default, this is a tenth of PollInterval.
+	PollJitter time.Duration
+
+	// Deliver events to the handler one at a time, in order, so it never
+	// runs concurrently with itself.
+	Serialize bool
+
+	// Merge events for a file into the one still waiting for delivery,
+	// if any. Implies Serialize.
+	Coalesce bool
+
+	// Hold back events until there were no further events for the same
+	// file for this long, and deliver them merged into one. Implies
+	// Coalesce.
+	Debounce time.Duration
+
+	// Treat the path of the URL being watched as a glob pattern, as
+	// described for MatchPattern, rather than as the name of a file.
+	Glob bool
+}
+
+// Function setting an option on WatchOptions.
+type WatchOption func(*WatchOptions)
+
+// Create a new set of WatchOptions with the defaults, modified by "opts".
+func NewWatchOptions(opts ...WatchOption) *WatchOptions {
+	var ret = &WatchOptions{
+		PollInterval: DefaultPollInterval,
+		PollJitter:   -1,
+	}
+	var opt WatchOption
+
+	for _, opt = range opts {
+		opt(ret)
+	}
+
+	// Polling without any pause would read the files continuously.
+	if ret.PollInterval <= 0 {
+		ret.PollInterval = DefaultPollInterval
+	}
+	if ret.PollJitter < 0 {
+		ret.PollJitter = ret.PollInterval / 10
+	}
+	return ret
+}
+
+// Watch by polling even if the backend can watch files natively. This is
+// useful for file systems where native notifications are unreliable, such
+// as network file systems mounted locally.
+func WithPolling() WatchOption {
+	return func(o *WatchOptions) {
+		o.Poll = true
+	}
+}
+
+// Poll for changes every "interval" when watching by polling. Zero or
+// less selects DefaultPollInterval.
+func WithPollInterval(interval time.Duration) WatchOption {
+	return func(o *WatchOptions) {
+		o.PollInterval = interval
+	}
+}
+
+// Add a random delay of up to "jitter" to each poll interval. Zero
+// disables the jitter.
+func WithPollJitter(jitter time.Duration) WatchOption {
+	return func(o *WatchOptions) {
+		o.PollJitter = jitter
+	}
+}
+
+// Deliver events one at a time, in order, so the handler never runs
+// concurrently with itself and slow handlers don't block the watcher.
+func WithSerialize() WatchOption {
+	return func(o *WatchOptions) {
+		o.Serialize = true
+	}
+}
+
+// Merge events for a file into the event still waiting for delivery for
+// it, if any, so a handler which can't keep up only sees the latest state.
+// Implies WithSerialize.
+func WithCoalesce() WatchOption {
+	return func(o *WatchOptions) {
+		o.Coalesce = true
+	}
+}
+
+// Deliver events for a file only once there were no further events for it
+// for "quiet", merged into a single event. This turns the several events
+// caused by e.g. an editor saving a file into one. Implies WithCoalesce.
+func WithDebounce(quiet time.Duration) WatchOption {
+	return func(o *WatchOptions) {
+		o.Debounce = quiet
+	}
+}
+
+// Treat the path of the URL being watched as a glob pattern, and deliver
+// events for all files matching it. Without this option, characters such
+// as "*" or "[" are taken literally, as they may well be part of the name
+// of the file being watched.
+func WithGlob() WatchOption {
+	return func(o *WatchOptions) {
+		o.Glob = true
+	}
+}
+
+// Determine whether events need to be passed through an EventQueue to
+// satisfy the options.
+func (o *WatchOptions) queued() bool {
+	return o.Serialize || o.Coalesce || o.Debounce > 0
+}
@@ -0,0 +1,110 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"net/url"
+	"sync"
+)
+
+// Number of errors buffered by a WatchStatus. Further errors are dropped
+// until the buffered ones have been read.
+const WatchErrChanSize = 16
+
+// Bookkeeping implementing the error reporting and shutdown contract of a
+// Watcher, for use by watcher implementations. Errors are reported without
+// ever blocking, and reporting errors after the watcher has stopped is
+// harmless, so watch loops don't need to coordinate with Shutdown.
+type WatchStatus struct {
+	url     *url.URL
+	errchan chan error
+	done    chan struct{}
+
+	mtx    sync.Mutex
+	closed bool
+}
+
+// Create a new WatchStatus for a watcher of "u".
+func NewWatchStatus(u *url.URL) *WatchStatus {
+	return &WatchStatus{
+		url:     u,
+		errchan: make(chan error, WatchErrChanSize),
+		done:    make(chan struct{}),
+	}
+}
+
+// Report "err" on the error channel. Errors which aren't of type *Error yet
+// are wrapped into one for the operation "watch" on the watched URL. The
+// error is dropped if the channel is full or the watcher has stopped.
+func (s *WatchStatus) Report(err error) {
+	if err == nil {
+		return
+	}
+	err = WrapError("watch", s.url, err)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return
+	}
+	select {
+	case s.errchan <- err:
+	default:
+	}
+}
+
+// Mark the watcher as stopped, closing both the error channel and the Done
+// channel. This must be called once the watcher won't invoke its handler
+// anymore. It is safe to call this more than once.
+func (s *WatchStatus) Close() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.errchan)
+	close(s.done)
+}
+
+// Get the error channel, as returned by Watcher.ErrChan().
+func (s *WatchStatus) ErrChan() chan error {
+	return s.errchan
+}
+
+// Get the channel closed once the watcher has stopped, as returned by
+// DoneWatcher.Done().
+func (s *WatchStatus) Done() <-chan struct{} {
+	return s.done
+}
@@ -34,6 +34,9 @@ package file
 import (
 	"io"
 	"net/url"
+	"sync"
+
+	"golang.org/x/net/context"
 )
 
 // Objects describing how to watch a specific type of files, identified
@@ -43,24 +46,144 @@ type WatcherCreator interface {
 	Watch(*url.URL, func(string, io.ReadCloser)) (Watcher, error)
 }
 
+// WatcherCreators which can additionally take a context. The watcher
+// created by WatchContext will be shut down once the context is done.
+type ContextWatcherCreator interface {
+	WatchContext(context.Context, *url.URL, func(string, io.ReadCloser)) (
+		Watcher, error)
+}
+
 // Watchers are the objects doing the actual watching of individual
 // files. They are configured by the WatcherCreator and will continue
 // invoking their configured handlers until Shutdown() is called.
+//
+// Errors which occur while watching are reported as *Error, carrying the
+// watched URL, on a buffered error channel. Watchers never block on it;
+// errors which occur while it is full are dropped. Once a watcher has
+// stopped, either because it was shut down or because it can't continue,
+// its error channel is closed. WatchStatus can be used to implement this.
 type Watcher interface {
-	// Stop listening for notifications on the given file. This may take
-	// until the next event to take effect.
+	// Stop listening for notifications on the given file. Shutdown
+	// doesn't wait for the watcher to stop; watchers implementing
+	// DoneWatcher tell when they have. It is safe to call this more
+	// than once.
 	Shutdown() error
 
 	// Retrieve the error channel associated with the watcher.
-	// It will stream a list of all errors created while watching.
+	// It will stream a list of all errors created while watching, and
+	// is closed once the watcher has stopped.
 	ErrChan() chan error
 }
 
-// List of URL schema handlers known.
-var fileWatcherHandlers map[string]WatcherCreator = make(map[string]WatcherCreator)
+// Watchers which can additionally tell when they have stopped. All
+// watchers returned by the Registry implement this; watchers which don't
+// are wrapped into one whose Done channel is closed once Shutdown has
+// returned.
+type DoneWatcher interface {
+	Watcher
+
+	// Retrieve a channel which is closed once the watcher has stopped
+	// and won't invoke its handler anymore. Handlers must not wait for
+	// it, since it isn't closed before they have returned.
+	Done() <-chan struct{}
+}
 
 // Register "creator" as a handler for watchers for all URLs with the given
-// "schema".
+// "schema". Any watcher creator previously registered for "schema" is
+// replaced.
 func RegisterWatcher(schema string, creator WatcherCreator) {
-	fileWatcherHandlers[schema] = creator
+	DefaultRegistry.RegisterWatcher(schema, creator)
+}
+
+// Remove the watcher creator registered for "schema", if any.
+func UnregisterWatcher(schema string) {
+	DefaultRegistry.UnregisterWatcher(schema)
+}
+
+// Wrapper around watchers which don't know about contexts or don't
+// implement DoneWatcher, shutting them down once the associated context is
+// done and telling when they have stopped.
+type contextWatcher struct {
+	Watcher
+	stop     chan bool
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Wrap "watcher", as returned together with "err" by a WatcherCreator,
+// into a DoneWatcher unless it already is one. For watchers which don't
+// implement DoneWatcher, the Done channel is closed once Shutdown has
+// returned.
+func doneWatcher(watcher Watcher, err error) (DoneWatcher, error) {
+	var dw DoneWatcher
+	var ok bool
+
+	if err != nil {
+		return nil, err
+	}
+	if dw, ok = watcher.(DoneWatcher); ok {
+		return dw, nil
+	}
+	return newContextWatcher(watcher), nil
+}
+
+// Arrange for "watcher" to be shut down once "ctx" is done. If "ctx" can
+// never be done, "watcher" is only wrapped into a DoneWatcher if needed.
+func shutdownOnDone(ctx context.Context, watcher Watcher) DoneWatcher {
+	var ret *contextWatcher
+	var dw DoneWatcher
+
+	if ctx.Done() == nil {
+		dw, _ = doneWatcher(watcher, nil)
+		return dw
+	}
+
+	ret = newContextWatcher(watcher)
+	go ret.waitForDone(ctx)
+	return ret
+}
+
+// Create a new wrapper around "watcher".
+func newContextWatcher(watcher Watcher) *contextWatcher {
+	return &contextWatcher{
+		Watcher: watcher,
+		stop:    make(chan bool),
+		done:    make(chan struct{}),
+	}
+}
+
+// Wait for either the context or the watcher to be done and shut down
+// the watcher.
+func (c *contextWatcher) waitForDone(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		c.Shutdown()
+	case <-c.stop:
+	case <-c.Done():
+	}
+}
+
+// Stop listening for notifications on the wrapped watcher. It is safe to
+// call this more than once; only the first call has any effect.
+func (c *contextWatcher) Shutdown() error {
+	var err error
+
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		err = c.Watcher.Shutdown()
+		close(c.done)
+	})
+	return err
+}
+
+// Retrieve a channel which is closed once the wrapped watcher has stopped.
+// If it doesn't tell, this is once Shutdown has returned.
+func (c *contextWatcher) Done() <-chan struct{} {
+	var dw DoneWatcher
+	var ok bool
+
+	if dw, ok = c.Watcher.(DoneWatcher); ok {
+		return dw.Done()
+	}
+	return c.done
 }
@@ -0,0 +1,154 @@
+/*
+ * (c) 2026, Caoimhe Chaos <caoimhechaos@protonmail.com>,
+ *	     Starship Factory. All rights reserved.
+ *
+ * Redistribution and use in source  and binary forms, with or without
+ * modification, are permitted  provided that the following conditions
+ * are met:
+ *
+ * * Redistributions of  source code  must retain the  above copyright
+ *   notice, this list of conditions and the following disclaimer.
+ * * Redistributions in binary form must reproduce the above copyright
+ *   notice, this  list of conditions and the  following disclaimer in
+ *   the  documentation  and/or  other  materials  provided  with  the
+ *   distribution.
+ * * Neither  the name  of the Starship Factory  nor the  name  of its
+ *   contributors may  be used to endorse or  promote products derived
+ *   from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS"  AND ANY EXPRESS  OR IMPLIED WARRANTIES  OF MERCHANTABILITY
+ * AND FITNESS  FOR A PARTICULAR  PURPOSE ARE DISCLAIMED. IN  NO EVENT
+ * SHALL THE COPYRIGHT OWNER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL,  EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED  TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE,  DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT  LIABILITY,  OR  TORT  (INCLUDING NEGLIGENCE  OR  OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package file
+
+import (
+	"os"
+	"time"
+)
+
+// Options for writing a file, e.g. conditions which must hold for the file
+// to be written at all. If a condition doesn't hold, the file is left as it
+// is and an error of the kind ErrPreconditionFailed is returned, either by
+// OpenForWrite or by Close on the writer, depending on the backend.
+type WriteOptions struct {
+	// Only write the file if it doesn't exist yet.
+	IfAbsent bool
+
+	// Only write the file if it exists already.
+	IfPresent bool
+
+	// Only write the file if it hasn't been modified since it had the
+	// given ETag, as returned by ETag() for the FileInfo from Stat.
+	// Empty means the file is written regardless of its version.
+	IfMatch string
+
+	// Replace the file atomically when the writer is closed, so readers
+	// never see it partially written and its previous contents survive
+	// a crash while writing. Backends which always write files this way
+	// accept it as well.
+	Atomic bool
+
+	// Have the file removed automatically after this long, unless the
+	// backend is told otherwise in the meantime, e.g. by keeping an etcd
+	// lease alive. Zero means the file is kept until it is removed.
+	// Backends which can't expire files reject it.
+	TTL time.Duration
+}
+
+// Function setting an option for writing a file.
+type WriteOption func(*WriteOptions)
+
+// Create a new set of WriteOptions with the defaults, modified by "opts".
+func NewWriteOptions(opts ...WriteOption) *WriteOptions {
+	var ret = &WriteOptions{}
+	var opt WriteOption
+
+	for _, opt = range opts {
+		opt(ret)
+	}
+	return ret
+}
+
+// Only write the file if it doesn't exist yet.
+func WithIfAbsent() WriteOption {
+	return func(o *WriteOptions) {
+		o.IfAbsent = true
+	}
+}
+
+// Only write the file if it exists already.
+func WithIfPresent() WriteOption {
+	return func(o *WriteOptions) {
+		o.IfPresent = true
+	}
+}
+
+// Only write the file if it is still at the version described by "etag",
+// which has been obtained using ETag().
+func WithIfMatch(etag string) WriteOption {
+	return func(o *WriteOptions) {
+		o.IfMatch = etag
+	}
+}
+
+// Replace the file atomically once the writer is closed, rather than
+// writing to it in place.
+func WithAtomic() WriteOption {
+	return func(o *WriteOptions) {
+		o.Atomic = true
+	}
+}
+
+// Have the file removed automatically once "ttl" has passed without the
+// backend being told to keep it. How that is done depends on the backend;
+// see EtcdLease in the etcd package, for example.
+func WithTTL(ttl time.Duration) WriteOption {
+	return func(o *WriteOptions) {
+		o.TTL = ttl
+	}
+}
+
+// Determine whether the file should only be written if some condition
+// holds.
+func (o *WriteOptions) Conditional() bool {
+	return o.IfAbsent || o.IfPresent || o.IfMatch != ""
+}
+
+// Determine whether the conditions contradict each other, so the file can
+// never be written.
+func (o *WriteOptions) contradictory() bool {
+	return o.IfAbsent && (o.IfPresent || o.IfMatch != "")
+}
+
+// FileInfo, or values returned by its Sys() method, which can describe the
+// version of the file they were obtained for. ETags of different versions
+// of the same file must differ.
+type ETagger interface {
+	ETag() string
+}
+
+// Get the ETag describing the version of the file "fi" was obtained for,
+// e.g. for use with WithIfMatch. Returns the empty string if the backend
+// doesn't provide ETags.
+func ETag(fi os.FileInfo) string {
+	var tagger ETagger
+	var ok bool
+
+	if tagger, ok = fi.(ETagger); ok {
+		return tagger.ETag()
+	}
+	if tagger, ok = fi.Sys().(ETagger); ok {
+		return tagger.ETag()
+	}
+	return ""
+}